@@ -6,14 +6,22 @@
  */
 package comm
 
-import "log"
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
 
 const (
 	Version = "1.6.0"
+
+	DefaultProcDir = "/proc"
 )
 
 var (
-	debug = false
+	debug   = false
+	procDir = DefaultProcDir
 )
 
 func SetDebug(de bool) {
@@ -24,3 +32,25 @@ func SetDebug(de bool) {
 func Debug() bool {
 	return debug
 }
+
+/*
+ *  @Description: set root of procfs, such as /host/proc when the exporter runs in container
+ */
+func SetProcDir(dir string) error {
+	dir = filepath.Clean(dir)
+	if fi, err := os.Stat(dir); err != nil {
+		return err
+	} else if !fi.IsDir() {
+		return fmt.Errorf("procfs %s is not directory", dir)
+	}
+	procDir = dir
+	log.Printf("set procfs: %s", procDir)
+	return nil
+}
+
+/*
+ *  @Description: root of procfs
+ */
+func ProcDir() string {
+	return procDir
+}
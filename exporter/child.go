@@ -0,0 +1,44 @@
+// Package exporter
+// @Description: aggregate child processes of listen process
+package exporter
+
+import (
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"listen_process_exporter/comm"
+	"listen_process_exporter/listen_process"
+)
+
+var (
+	childMetrics = newGroupDescs("listen_port_child_", "count", "child processes of processes holding the listen socket", processRole)
+)
+
+/*
+ *  @Description: collect sum of descendants of processes holding the listen socket by role, such as backends of postgres
+ */
+func (e *Exporter) collectChildren(ch chan<- prometheus.Metric, tree listen_process.ProcessTree, listenProcess listen_process.ListenProcess) {
+	pids := make([]int32, 0, len(listenProcess.Processes))
+	for _, p := range listenProcess.Processes {
+		pids = append(pids, p.Pid)
+	}
+	rules, roles := childRoles(listenProcess)
+	groups := make(map[string]*groupStats, len(roles))
+	for _, role := range roles {
+		groups[role] = &groupStats{}
+	}
+	for _, pid := range tree.Descendants(pids) {
+		processStats, err := collectProcessStat(e.ctx, pid)
+		if processStats.Stat == nil {
+			// child exited
+			continue
+		}
+		if err != nil && comm.Debug() {
+			log.Printf("query listen %s child pid %d error: %v", listenProcess.Key(), pid, err)
+		}
+		groups[childRole(rules, processStats)].add(processStats)
+	}
+	for _, role := range roles {
+		childMetrics.collect(ch, *groups[role], socketLabelValues(listenProcess, role))
+	}
+}
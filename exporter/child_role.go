@@ -0,0 +1,145 @@
+// Package exporter
+// @Description: classify child processes into roles by cmdline or comm
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"listen_process_exporter/listen_process"
+)
+
+const (
+	// role of child process matching no rule
+	ChildRoleOther = "other"
+)
+
+/*
+ *  @Description: child process whose cmdline or comm matches the regex has the role
+ */
+type ChildRoleRule struct {
+	Role    string `json:"role"`
+	Cmdline string `json:"cmdline,omitempty"`
+	Comm    string `json:"comm,omitempty"`
+
+	cmdline *regexp.Regexp
+	comm    *regexp.Regexp
+}
+
+var (
+	// rules by listen port, the first matching rule wins
+	childRoleRules = map[uint32][]ChildRoleRule{}
+)
+
+/*
+ * @Description: load rules of child role from json file, such as
+ *               {"5432": [{"role": "checkpointer", "cmdline": "^postgres: checkpointer"},
+ *                         {"role": "idle", "cmdline": "^postgres: .* idle$"}]}
+ * @Param path: empty to disable
+ * @Return error:
+ */
+func SetChildRoleFile(path string) error {
+	if path == "" {
+		childRoleRules = map[uint32][]ChildRoleRule{}
+		return nil
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var byPort map[string][]ChildRoleRule
+	if err = json.Unmarshal(b, &byPort); err != nil {
+		return fmt.Errorf("child role file %s invalid: %v", path, err)
+	}
+	rules := make(map[uint32][]ChildRoleRule, len(byPort))
+	for p, list := range byPort {
+		port, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return fmt.Errorf("child role port[%s] must be number", p)
+		}
+		for i := range list {
+			if err = list[i].compile(); err != nil {
+				return fmt.Errorf("child role of port %d: %v", port, err)
+			}
+		}
+		rules[uint32(port)] = list
+	}
+	childRoleRules = rules
+	log.Printf("set child role rules of %d ports from %s", len(rules), path)
+	return nil
+}
+
+func (r *ChildRoleRule) compile() error {
+	if r.Role == "" || r.Role == ChildRoleOther {
+		return fmt.Errorf("role[%s] is reserved", r.Role)
+	}
+	if r.Cmdline == "" && r.Comm == "" {
+		return fmt.Errorf("role %s requires cmdline or comm", r.Role)
+	}
+	var err error
+	if r.Cmdline != "" {
+		if r.cmdline, err = regexp.Compile(r.Cmdline); err != nil {
+			return fmt.Errorf("role %s cmdline regex invalid: %v", r.Role, err)
+		}
+	}
+	if r.Comm != "" {
+		if r.comm, err = regexp.Compile(r.Comm); err != nil {
+			return fmt.Errorf("role %s comm regex invalid: %v", r.Role, err)
+		}
+	}
+	return nil
+}
+
+/*
+ *  @Description: both cmdline and comm must match when both are set
+ */
+func (r ChildRoleRule) match(processStats ProcessStats) bool {
+	if r.cmdline != nil && !r.cmdline.MatchString(processStats.Cmdline) {
+		return false
+	}
+	// comm of goprocinfo keeps the parentheses of /proc/<pid>/stat
+	comm := strings.TrimSuffix(strings.TrimPrefix(processStats.Stat.Comm, "("), ")")
+	if r.comm != nil && !r.comm.MatchString(comm) {
+		return false
+	}
+	return true
+}
+
+/*
+ * @Description: roles of child process of listen process, every role is reported even without process
+ * @Param listenProcess:
+ * @Return []ChildRoleRule: rules of the listen port
+ * @Return []string: roles in the order of rules, other comes last
+ */
+func childRoles(listenProcess listen_process.ListenProcess) ([]ChildRoleRule, []string) {
+	var rules []ChildRoleRule
+	if listenProcess.Protocol == listen_process.ProtocolTCP || listenProcess.Protocol == listen_process.ProtocolUDP {
+		rules = childRoleRules[listenProcess.Port]
+	}
+	roles := make([]string, 0, len(rules)+1)
+	seen := make(map[string]struct{}, len(rules))
+	for _, r := range rules {
+		if _, ok := seen[r.Role]; !ok {
+			seen[r.Role] = struct{}{}
+			roles = append(roles, r.Role)
+		}
+	}
+	return rules, append(roles, ChildRoleOther)
+}
+
+/*
+ *  @Description: role of the first matching rule
+ */
+func childRole(rules []ChildRoleRule, processStats ProcessStats) string {
+	for _, r := range rules {
+		if r.match(processStats) {
+			return r.Role
+		}
+	}
+	return ChildRoleOther
+}
@@ -0,0 +1,139 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"listen_process_exporter/listen_process"
+)
+
+/*
+ *  @Description: set rules of child role from json during the test
+ */
+func useChildRoleRules(t *testing.T, rules string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "child_roles.json")
+	if err := os.WriteFile(path, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetChildRoleFile(path); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = SetChildRoleFile("")
+	})
+}
+
+/*
+ *  @Description: write /proc/<pid>/stat and cmdline of processes, and use it as proc dir during the test
+ */
+func writeChildProcs(t *testing.T, procs map[int32]string) {
+	t.Helper()
+	root := t.TempDir()
+	for pid, cmdline := range procs {
+		dir := filepath.Join(root, strconv.Itoa(int(pid)))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		ppid := pid / 10
+		stat := fmt.Sprintf("%d (postgres) S %d %d %d 0 -1 4194560 100 0 0 0 10 5 0 0 20 0 1 0 %d "+
+			"1000000 200 18446744073709551615 0 0 0 0 0 0 0 0 0 0 0 0 17 0 0 0 0 0 0 0 0 0 0 0 0 0 0\n", pid, ppid, pid, pid, 1000+pid)
+		if err := os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "cmdline"), []byte(strings.ReplaceAll(cmdline, " ", "\x00")+"\x00"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	useProcDir(t, root)
+}
+
+func TestChildRole(t *testing.T) {
+	useChildRoleRules(t, `{"5432": [
+		{"role": "checkpointer", "cmdline": "^postgres: checkpointer"},
+		{"role": "idle", "cmdline": "^postgres: .* idle$"},
+		{"role": "backend", "cmdline": "^postgres: \\w+ \\w+ [0-9.]+\\("},
+		{"role": "idle", "cmdline": "idle in transaction", "comm": "^postgres$"}]}`)
+	// postmaster 50 holds the socket, parent of child is pid/10
+	writeChildProcs(t, map[int32]string{
+		50:   "/usr/lib/postgresql/16/bin/postgres -D /var/lib/postgresql/16/main",
+		500:  "postgres: checkpointer ",
+		501:  "postgres: app db 10.0.0.1(51234) idle",
+		502:  "postgres: app db 10.0.0.1(51236) SELECT",
+		503:  "postgres: walwriter ",
+		5020: "postgres: parallel worker for PID 502",
+	})
+	tree, err := listen_process.LoadProcessTree(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	lp := listen_process.ListenProcess{Pid: 50, Processes: []listen_process.SocketProcess{{Pid: 50}},
+		Port: 5432, Protocol: listen_process.ProtocolTCP}
+	rules, roles := childRoles(lp)
+	if want := []string{"checkpointer", "idle", "backend", ChildRoleOther}; !reflect.DeepEqual(roles, want) {
+		t.Errorf("roles = %v, want %v", roles, want)
+	}
+	got := make(map[int32]string)
+	for _, pid := range tree.Descendants([]int32{50}) {
+		processStats, _ := collectProcessStat(context.Background(), pid)
+		got[pid] = childRole(rules, processStats)
+	}
+	// grandchild is aggregated too
+	want := map[int32]string{500: "checkpointer", 501: "idle", 502: "backend", 503: ChildRoleOther, 5020: ChildRoleOther}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("child roles = %v, want %v", got, want)
+	}
+
+	// rules of other ports and unix socket do not apply
+	for _, other := range []listen_process.ListenProcess{
+		{Port: 3306, Protocol: listen_process.ProtocolTCP},
+		{Protocol: listen_process.ProtocolUnix, Path: "/run/postgresql/.s.PGSQL.5432"},
+	} {
+		if rules, roles := childRoles(other); len(rules) != 0 || !reflect.DeepEqual(roles, []string{ChildRoleOther}) {
+			t.Errorf("rules of %s = %v roles %v, want only %s", other.Key(), rules, roles, ChildRoleOther)
+		}
+	}
+}
+
+func TestChildRoleRuleMatchBoth(t *testing.T) {
+	useProcDir(t, fixtureProcDir)
+	useChildRoleRules(t, `{"3306": [
+		{"role": "worker", "cmdline": "--user=mysql", "comm": "worker$"},
+		{"role": "mysqld", "comm": "^mysqld$"}]}`)
+	rules, _ := childRoles(listen_process.ListenProcess{Port: 3306, Protocol: listen_process.ProtocolTCP})
+	// the worker 1240 of fixture has comm "mysqld worker"
+	for pid, want := range map[int32]string{1234: "mysqld", 1240: "worker"} {
+		processStats, _ := collectProcessStat(context.Background(), pid)
+		if got := childRole(rules, processStats); got != want {
+			t.Errorf("role of pid %d comm %s = %s, want %s", pid, processStats.Stat.Comm, got, want)
+		}
+	}
+}
+
+func TestSetChildRoleFileInvalid(t *testing.T) {
+	for _, rules := range []string{
+		`{"5432": [{"role": "idle"}]}`,
+		`{"5432": [{"role": "", "cmdline": "idle"}]}`,
+		`{"5432": [{"role": "other", "cmdline": "idle"}]}`,
+		`{"5432": [{"role": "idle", "cmdline": "idle("}]}`,
+		`{"postgres": [{"role": "idle", "cmdline": "idle"}]}`,
+		`{"5432": {"role": "idle"}}`,
+	} {
+		path := filepath.Join(t.TempDir(), "child_roles.json")
+		if err := os.WriteFile(path, []byte(rules), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := SetChildRoleFile(path); err == nil {
+			t.Errorf("SetChildRoleFile(%s) = nil, want error", rules)
+		}
+	}
+	t.Cleanup(func() {
+		_ = SetChildRoleFile("")
+	})
+}
@@ -0,0 +1,73 @@
+// Package exporter
+// @Description: top clients connected to listen socket
+package exporter
+
+import (
+	"errors"
+	"log"
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"listen_process_exporter/listen_process"
+)
+
+var (
+	// 0 means disable
+	clientTopN        = 0
+	clientAggregation *listen_process.ClientAggregation
+
+	clientConnectionsDesc = prometheus.NewDesc(
+		"listen_port_client_connections",
+		"number of tcp connections of top clients connected to listen socket",
+		socketLabelNames("remote_ip", "client_type"), nil)
+)
+
+/*
+ * @Description: enable top clients of listen socket
+ * @Param topN: number of clients reported for loopback and external each, 0 to disable
+ * @Param ipv4Prefix: aggregate ipv4 client to network, such as 24, 32 means every ip
+ * @Param ipv6Prefix: aggregate ipv6 client to network, such as 64, 128 means every ip
+ * @Return error:
+ */
+func SetClientTopN(topN int, ipv4Prefix int, ipv6Prefix int) error {
+	if topN < 0 {
+		return errors.New("invalid client top n")
+	}
+	if ipv4Prefix < 1 || ipv4Prefix > 32 || ipv6Prefix < 1 || ipv6Prefix > 128 {
+		return errors.New("invalid client prefix length")
+	}
+	clientTopN = topN
+	if topN == 0 {
+		clientAggregation = nil
+		return nil
+	}
+	clientAggregation = &listen_process.ClientAggregation{IPv4Prefix: ipv4Prefix, IPv6Prefix: ipv6Prefix}
+	log.Printf("set client top %d ipv4 prefix %d ipv6 prefix %d", topN, ipv4Prefix, ipv6Prefix)
+	return nil
+}
+
+/*
+ *  @Description: collect connection count of top clients, loopback and external are ranked separately
+ */
+func (e *Exporter) collectClients(ch chan<- prometheus.Metric, listenProcess listen_process.ListenProcess, clients map[listen_process.Client]int) {
+	byType := make(map[string][]listen_process.Client)
+	for c := range clients {
+		byType[c.Type] = append(byType[c.Type], c)
+	}
+	for _, list := range byType {
+		sort.Slice(list, func(i, j int) bool {
+			if clients[list[i]] != clients[list[j]] {
+				return clients[list[i]] > clients[list[j]]
+			}
+			return list[i].Addr < list[j].Addr
+		})
+		if len(list) > clientTopN {
+			list = list[:clientTopN]
+		}
+		for _, c := range list {
+			ch <- prometheus.MustNewConstMetric(clientConnectionsDesc,
+				prometheus.GaugeValue, float64(clients[c]),
+				socketLabelValues(listenProcess, c.Addr, c.Type)...)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package exporter
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"listen_process_exporter/listen_process"
+)
+
+/*
+ *  @Description: enable top clients during the test
+ */
+func useClientTopN(t *testing.T, topN int) {
+	t.Helper()
+	if err := SetClientTopN(topN, 24, 64); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = SetClientTopN(0, 32, 128)
+	})
+}
+
+/*
+ *  @Description: connections of clients reported, such as external/10.0.0.0/24
+ */
+func collectClientValues(t *testing.T, clients map[listen_process.Client]int) map[string]float64 {
+	t.Helper()
+	lp := listen_process.ListenProcess{IP: "0.0.0.0", Port: 3306, Protocol: listen_process.ProtocolTCP}
+	ch := make(chan prometheus.Metric, 100)
+	(&Exporter{}).collectClients(ch, lp, clients)
+	close(ch)
+	values := make(map[string]float64)
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		labels := make(map[string]string)
+		for _, l := range pb.GetLabel() {
+			labels[l.GetName()] = l.GetValue()
+		}
+		values[labels["client_type"]+"/"+labels["remote_ip"]] = pb.GetGauge().GetValue()
+	}
+	return values
+}
+
+func TestCollectClientsTopN(t *testing.T) {
+	clients := map[listen_process.Client]int{
+		{Addr: "10.0.3.0/24", Type: listen_process.ClientExternal}:   5,
+		{Addr: "10.0.2.0/24", Type: listen_process.ClientExternal}:   3,
+		{Addr: "10.0.1.0/24", Type: listen_process.ClientExternal}:   3,
+		{Addr: "2001:db8::/64", Type: listen_process.ClientExternal}: 1,
+		{Addr: "127.0.0.0/24", Type: listen_process.ClientLoopback}:  2,
+	}
+	tests := []struct {
+		topN int
+		want map[string]float64
+	}{
+		// loopback and external are ranked separately, tie is broken by address
+		{topN: 1, want: map[string]float64{"external/10.0.3.0/24": 5, "loopback/127.0.0.0/24": 2}},
+		{topN: 2, want: map[string]float64{"external/10.0.3.0/24": 5, "external/10.0.1.0/24": 3, "loopback/127.0.0.0/24": 2}},
+		{topN: 10, want: map[string]float64{"external/10.0.3.0/24": 5, "external/10.0.1.0/24": 3, "external/10.0.2.0/24": 3,
+			"external/2001:db8::/64": 1, "loopback/127.0.0.0/24": 2}},
+	}
+	for _, tt := range tests {
+		useClientTopN(t, tt.topN)
+		if got := collectClientValues(t, clients); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("top %d clients = %v, want %v", tt.topN, got, tt.want)
+		}
+	}
+}
+
+func TestSetClientTopN(t *testing.T) {
+	tests := []struct {
+		topN, ipv4, ipv6 int
+		err              bool
+	}{
+		{topN: 10, ipv4: 24, ipv6: 64},
+		{topN: 10, ipv4: 32, ipv6: 128},
+		{topN: 0, ipv4: 32, ipv6: 128},
+		{topN: -1, ipv4: 24, ipv6: 64, err: true},
+		{topN: 10, ipv4: 0, ipv6: 64, err: true},
+		{topN: 10, ipv4: 33, ipv6: 64, err: true},
+		{topN: 10, ipv4: 24, ipv6: 0, err: true},
+		{topN: 10, ipv4: 24, ipv6: 129, err: true},
+	}
+	t.Cleanup(func() {
+		_ = SetClientTopN(0, 32, 128)
+	})
+	for _, tt := range tests {
+		err := SetClientTopN(tt.topN, tt.ipv4, tt.ipv6)
+		if (err != nil) != tt.err {
+			t.Errorf("SetClientTopN(%d, %d, %d) error = %v, want error %v", tt.topN, tt.ipv4, tt.ipv6, err, tt.err)
+			continue
+		}
+		if err == nil && (clientAggregation != nil) != (tt.topN > 0) {
+			t.Errorf("SetClientTopN(%d, %d, %d) aggregation = %+v", tt.topN, tt.ipv4, tt.ipv6, clientAggregation)
+		}
+	}
+}
@@ -4,19 +4,17 @@ package exporter
 
 import (
 	"context"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	linuxproc "github.com/c9s/goprocinfo/linux"
 	"listen_process_exporter/comm"
 )
 
-const (
-	LinuxProcDir = "/proc"
-)
-
 type ProcessStats struct {
 	ProcessID     int32                       `json:"pid"` // 进程id
 	Status        *linuxproc.ProcessStatus    `json:"status"`
@@ -26,60 +24,76 @@ type ProcessStats struct {
 	Schedule      *linuxproc.ProcessSchedStat `json:"schedule"`        // 调度信息
 	FileDescCount int                         `json:"file_desc_count"` // 打开的文件列表
 	Cmdline       string                      `json:"cmdline"`
+	Memory        *MemoryStats                `json:"memory"`          // in bytes
+	Smaps         *SmapsStats                 `json:"smaps,omitempty"` // nil unless smaps is enabled
 }
 
 /*
- *  @Description: collect process stats include stats
+ *  @Description: collect process stats include stats, error is the first file failed to read.
+ *                Stat is nil when the process is not found, otherwise the unreadable stats are empty
  */
 func collectProcessStat(ctx context.Context, pid int32) (processStats ProcessStats, err error) {
 	var (
-		p        = filepath.Join(LinuxProcDir, strconv.FormatInt(int64(pid), 10))
+		p        = filepath.Join(comm.ProcDir(), strconv.FormatInt(int64(pid), 10))
 		io       *linuxproc.ProcessIO
 		stat     *linuxproc.ProcessStat
 		statm    *linuxproc.ProcessStatm
 		status   *linuxproc.ProcessStatus
 		cmdline  string
+		memory   *MemoryStats
+		smaps    *SmapsStats
 		schedule *linuxproc.ProcessSchedStat
 		names    []string
+		readErr  error
 	)
+	failed := func(file string, err error) {
+		if comm.Debug() {
+			log.Printf("collect process [%d] %s error %v", pid, file, err)
+		}
+		if readErr == nil {
+			readErr = err
+		}
+	}
 
 	if _, err = os.Stat(p); err != nil {
 		return
 	}
 
 	if io, err = linuxproc.ReadProcessIO(filepath.Join(p, "io")); err != nil {
-		if comm.Debug() {
-			log.Printf("collect process [%d] io error %v", pid, err)
-		}
+		failed("io", err)
 		io = &linuxproc.ProcessIO{}
 	}
 	if stat, err = linuxproc.ReadProcessStat(filepath.Join(p, "stat")); err != nil {
-		if comm.Debug() {
-			log.Printf("collect process [%d] stat error %v", pid, err)
-		}
+		failed("stat", err)
 		stat = &linuxproc.ProcessStat{}
 	}
 	if statm, err = linuxproc.ReadProcessStatm(filepath.Join(p, "statm")); err != nil {
-		if comm.Debug() {
-			log.Printf("collect process [%d] statm error %v", pid, err)
-		}
+		failed("statm", err)
 		statm = &linuxproc.ProcessStatm{}
 	}
 	if status, err = linuxproc.ReadProcessStatus(filepath.Join(p, "status")); err != nil {
-		if comm.Debug() {
-			log.Printf("collect process [%d] status error %v", pid, err)
-		}
+		failed("status", err)
 		status = &linuxproc.ProcessStatus{}
 	}
+	if memory, err = readMemoryStatus(filepath.Join(p, "status")); err != nil {
+		failed("status", err)
+		memory = &MemoryStats{}
+	}
 	// not used
 	//if schedule, err = linuxproc.ReadProcessSchedStat(filepath.Join(p, "schedstat")); err != nil {
 	//	schedule = &linuxproc.ProcessSchedStat{}
 	//}
-	if names, err = fileDescriptors(filepath.Join(p, "fd")); err != nil {
-		if comm.Debug() {
-			log.Printf("collect process [%d] read fd error %v", pid, err)
+	if b, err := ioutil.ReadFile(filepath.Join(p, "cmdline")); err == nil {
+		cmdline = strings.TrimSpace(strings.ReplaceAll(string(b), "\x00", " "))
+	}
+	if collectSmaps {
+		if smaps, err = readSmaps(p); err != nil {
+			failed("smaps", err)
 		}
 	}
+	if names, err = fileDescriptors(filepath.Join(p, "fd")); err != nil {
+		failed("fd", err)
+	}
 
 	processStats = ProcessStats{
 		ProcessID:     pid,
@@ -89,9 +103,11 @@ func collectProcessStat(ctx context.Context, pid int32) (processStats ProcessSta
 		IO:            io,
 		Schedule:      schedule,
 		Cmdline:       cmdline,
+		Memory:        memory,
+		Smaps:         smaps,
 		FileDescCount: len(names),
 	}
-	return
+	return processStats, readErr
 }
 
 func fileDescriptors(dirPath string) ([]string, error) {
@@ -0,0 +1,129 @@
+package exporter
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"listen_process_exporter/comm"
+)
+
+// fake /proc tree of mysqld 1234 and its worker 1240 sharing 127.0.0.1:3306
+const fixtureProcDir = "../testdata/proc"
+
+/*
+ *  @Description: set proc dir during the test
+ */
+func useProcDir(t *testing.T, dir string) {
+	t.Helper()
+	old := comm.ProcDir()
+	if err := comm.SetProcDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = comm.SetProcDir(old)
+	})
+}
+
+func TestCollectProcessStat(t *testing.T) {
+	useProcDir(t, fixtureProcDir)
+	processStats, err := collectProcessStat(context.Background(), 1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if processStats.Stat == nil || processStats.Stat.Utime != 30901 || processStats.Stat.Stime != 31172 ||
+		processStats.Stat.NumThreads != 37 || processStats.Stat.Starttime != 321104591 {
+		t.Errorf("stat = %+v", processStats.Stat)
+	}
+	if processStats.IO.ReadBytes != 52322304 || processStats.IO.WriteBytes != 1048576 {
+		t.Errorf("io = %+v", processStats.IO)
+	}
+	if processStats.Status.VoluntaryCtxtSwitches != 54 || processStats.Status.NonvoluntaryCtxtSwitches != 134 {
+		t.Errorf("status = %+v", processStats.Status)
+	}
+	if want := (MemoryStats{
+		VmPeak: 1860812 * 1024, VmSize: 1817200 * 1024, VmHWM: 250000 * 1024, VmRSS: 240848 * 1024,
+		RssAnon: 200000 * 1024, RssFile: 40000 * 1024, RssShmem: 848 * 1024, VmData: 600000 * 1024,
+		VmStk: 132 * 1024, VmLib: 8000 * 1024, VmPTE: 1000 * 1024, VmSwap: 146172 * 1024,
+	}); *processStats.Memory != want {
+		t.Errorf("memory = %+v, want %+v", *processStats.Memory, want)
+	}
+	if processStats.FileDescCount != 7 {
+		t.Errorf("open fds = %d, want 7", processStats.FileDescCount)
+	}
+	if processStats.Cmdline != "/usr/sbin/mysqld --user=mysql" {
+		t.Errorf("cmdline = %q", processStats.Cmdline)
+	}
+	if processStats.Smaps != nil {
+		t.Errorf("smaps = %+v, want nil when disabled", processStats.Smaps)
+	}
+}
+
+func TestCollectProcessStatSmaps(t *testing.T) {
+	useProcDir(t, fixtureProcDir)
+	collectSmaps = true
+	defer func() {
+		collectSmaps = false
+	}()
+	processStats, err := collectProcessStat(context.Background(), 1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SmapsStats{Pss: 230000 * 1024, Uss: (28000 + 200848) * 1024, SwapPss: 140000 * 1024, AnonHugePages: 4096 * 1024}
+	if processStats.Smaps == nil || *processStats.Smaps != want {
+		t.Errorf("smaps = %+v, want %+v", processStats.Smaps, want)
+	}
+}
+
+func TestCollectProcessStatPartial(t *testing.T) {
+	useProcDir(t, fixtureProcDir)
+	// worker 1240 of the fixture has stat and fd only
+	processStats, err := collectProcessStat(context.Background(), 1240)
+	if err == nil {
+		t.Error("want error of missing io and status")
+	}
+	if processStats.Stat == nil || processStats.Stat.Utime != 100 {
+		t.Errorf("stat = %+v", processStats.Stat)
+	}
+	if processStats.Memory == nil || processStats.Memory.VmRSS != 0 || processStats.FileDescCount != 2 {
+		t.Errorf("memory = %+v open fds = %d", processStats.Memory, processStats.FileDescCount)
+	}
+
+	processStats, _ = collectProcessStat(context.Background(), 9999)
+	if processStats.Stat != nil {
+		t.Errorf("stat of missing process = %+v, want nil", processStats.Stat)
+	}
+}
+
+func TestCollectProcessContextSwitches(t *testing.T) {
+	useProcDir(t, fixtureProcDir)
+	processStats, err := collectProcessStat(context.Background(), 1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := make([]string, len(processLabelNames()))
+	ch := make(chan prometheus.Metric, 100)
+	(&Exporter{}).collectProcess(ch, processStats, labels)
+	close(ch)
+	got := make(map[string]float64)
+	for m := range ch {
+		if m.Desc() != contextSwitchesDesc {
+			continue
+		}
+		var pb dto.Metric
+		if err = m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range pb.GetLabel() {
+			if l.GetName() == "ctx_switch_type" {
+				got[l.GetValue()] = pb.GetCounter().GetValue()
+			}
+		}
+	}
+	// voluntary_ctxt_switches 54 and nonvoluntary_ctxt_switches 134 of the fixture
+	if want := map[string]float64{"voluntary": 54, "nonvoluntary": 134}; !reflect.DeepEqual(got, want) {
+		t.Errorf("context switches = %v, want %v", got, want)
+	}
+}
@@ -4,92 +4,167 @@ package exporter
 
 import (
 	"context"
+	"errors"
 	"log"
+	"path/filepath"
 	"strconv"
 
+	linuxproc "github.com/c9s/goprocinfo/linux"
 	"github.com/prometheus/client_golang/prometheus"
+	"listen_process_exporter/comm"
 	"listen_process_exporter/listen_process"
 )
 
 const (
 	listenPort     = "listen_port"
 	listProcessPID = "pid"
+	listenProtocol = "protocol"
+	listenAddr     = "listen_addr"
+	socketPath     = "socket_path"
+	processRole    = "role"
+	listenNetns    = "netns"
+	forwarderPID   = "forwarder_pid"
+	probeTarget    = "target"
 	// See https://github.com/prometheus/procfs/blob/master/proc_stat.go for details on userHZ.
 	userHZ = 100
 )
 
 type Exporter struct {
-	listenPort          int
+	ctx                 context.Context // context of the probe request, socket tables are shared within the probe
+	discoverers         []listen_process.Discoverer
 	collectChildProcess bool
 	listenProcess       map[uint32]listen_process.ListenProcess
 	debug               bool
 }
 
+var (
+	// labels of every listen_port_process_* metric
+	processLabels = []string{listenPort, listProcessPID, listenProtocol, listenAddr, socketPath, processRole, listenNetns, forwarderPID}
+	// labels of every listen_port_socket_* metric
+	socketLabels = []string{listenPort, listenProtocol, listenAddr, listenNetns}
+)
+
 var (
 	numThreadDesc = prometheus.NewDesc(
 		"listen_port_process_thread_count",
 		"number of threads in listen process",
-		[]string{listenPort, listProcessPID}, nil)
+		processLabelNames(), nil)
 
 	cpuSecsDesc = prometheus.NewDesc(
 		"listen_port_process_cpu_seconds_total",
 		"Cpu user usage in seconds",
-		[]string{listenPort, listProcessPID, "mode"}, nil)
+		processLabelNames("mode"), nil)
 
 	readBytesDesc = prometheus.NewDesc(
 		"listen_port_process_read_bytes_total",
 		"number of bytes read by this process",
-		[]string{listenPort, listProcessPID}, nil)
+		processLabelNames(), nil)
 
 	readCallsDesc = prometheus.NewDesc(
 		"listen_port_process_read_calls_total",
 		"number of calls read by this process",
-		[]string{listenPort, listProcessPID}, nil)
+		processLabelNames(), nil)
 
 	writeBytesDesc = prometheus.NewDesc(
 		"listen_port_process_write_bytes_total",
 		"number of bytes written by this process",
-		[]string{listenPort, listProcessPID}, nil)
+		processLabelNames(), nil)
 
 	writeCallsDesc = prometheus.NewDesc(
 		"listen_port_process_write_calls_total",
 		"number of calls written by this process",
-		[]string{listenPort, listProcessPID}, nil)
+		processLabelNames(), nil)
 
 	majorPageFaultsDesc = prometheus.NewDesc(
 		"listen_port_process_major_page_faults_total",
 		"Major page faults",
-		[]string{listenPort, listProcessPID}, nil)
+		processLabelNames(), nil)
 
 	minorPageFaultsDesc = prometheus.NewDesc(
 		"listen_port_process_minor_page_faults_total",
 		"Minor page faults",
-		[]string{listenPort, listProcessPID}, nil)
+		processLabelNames(), nil)
 
 	contextSwitchesDesc = prometheus.NewDesc(
 		"listen_port_process_context_switches_total",
 		"Context switches",
-		[]string{listenPort, listProcessPID, "ctx_switch_type"}, nil)
+		processLabelNames("ctx_switch_type"), nil)
 
 	memBytesDesc = prometheus.NewDesc(
 		"listen_port_process_memory_bytes",
 		"number of bytes of memory in use",
-		[]string{listenPort, listProcessPID, "memory_type"}, nil)
+		processLabelNames("memory_type"), nil)
 
 	openFDsDesc = prometheus.NewDesc(
 		"listen_port_process_open_file_desc",
 		"number of open file descriptors for this group",
-		[]string{listenPort, listProcessPID}, nil)
+		processLabelNames(), nil)
 
 	startTimeDesc = prometheus.NewDesc(
 		"listen_port_process_oldest_start_time_seconds",
 		"start time in seconds since 1970/01/01 of listen process",
-		[]string{listenPort, listProcessPID}, nil)
+		processLabelNames(), nil)
+
+	socketRxQueueDesc = prometheus.NewDesc(
+		"listen_port_socket_receive_queue_bytes",
+		"number of bytes in the receive queue of udp listen socket",
+		socketLabelNames(), nil)
+
+	socketDropsDesc = prometheus.NewDesc(
+		"listen_port_socket_drops_total",
+		"number of datagrams dropped by udp listen socket",
+		socketLabelNames(), nil)
+
+	socketAcceptQueueDesc = prometheus.NewDesc(
+		"listen_port_socket_accept_queue_length",
+		"number of connections waiting to be accepted by tcp listen socket",
+		socketLabelNames(), nil)
+
+	socketBacklogDesc = prometheus.NewDesc(
+		"listen_port_socket_backlog_max",
+		"max length of accept queue of tcp listen socket",
+		socketLabelNames(), nil)
+
+	connectionsDesc = prometheus.NewDesc(
+		"listen_port_connections",
+		"number of tcp connections of listen socket by tcp state",
+		socketLabelNames("state"), nil)
+
+	scanSkippedPidsDesc = prometheus.NewDesc(
+		"listen_port_discovery_skipped_pids",
+		"number of pids skipped by the last scan of /proc/<pid>/fd",
+		[]string{"reason"}, nil)
+
+	scanHungDesc = prometheus.NewDesc(
+		"listen_port_discovery_hung_scans",
+		"number of pids whose /proc/<pid>/fd scan timed out and is still blocked in the kernel",
+		nil, nil)
+
+	scanDurationDesc = prometheus.NewDesc(
+		"listen_port_discovery_scan_duration_seconds",
+		"duration of the last scan of /proc/<pid>/fd",
+		nil, nil)
+
+	listenOverflowsDesc = prometheus.NewDesc(
+		"listen_port_host_listen_overflows_total",
+		"times the accept queue of a listen socket overflowed on this host, TcpExt ListenOverflows of /proc/net/netstat",
+		nil, nil)
+
+	listenDropsDesc = prometheus.NewDesc(
+		"listen_port_host_listen_drops_total",
+		"SYNs to listen sockets dropped on this host, TcpExt ListenDrops of /proc/net/netstat",
+		nil, nil)
+
+	notFoundDesc = prometheus.NewDesc(
+		"listen_port_not_found",
+		"target of probe without listen process",
+		[]string{probeTarget}, nil)
 )
 
-func NewExporter(collectChildProcess bool, listenPort int) *Exporter {
+func NewExporter(ctx context.Context, collectChildProcess bool, discoverers []listen_process.Discoverer) *Exporter {
 	return &Exporter{
-		listenPort:          listenPort,
+		ctx:                 listen_process.WithSocketTables(ctx),
+		discoverers:         discoverers,
 		collectChildProcess: collectChildProcess,
 		listenProcess:       make(map[uint32]listen_process.ListenProcess),
 	}
@@ -103,85 +178,345 @@ func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
 	ch <- writeBytesDesc
 	ch <- writeCallsDesc
 	ch <- memBytesDesc
+	ch <- memPeakBytesDesc
 	ch <- openFDsDesc
 	ch <- startTimeDesc
 	ch <- majorPageFaultsDesc
 	ch <- minorPageFaultsDesc
 	ch <- contextSwitchesDesc
+	ch <- socketRxQueueDesc
+	ch <- socketDropsDesc
+	ch <- socketAcceptQueueDesc
+	ch <- socketBacklogDesc
+	ch <- connectionsDesc
+	ch <- clientConnectionsDesc
+	ch <- scanSkippedPidsDesc
+	ch <- scanHungDesc
+	ch <- scanDurationDesc
+	ch <- listenOverflowsDesc
+	ch <- listenDropsDesc
+	ch <- notFoundDesc
+	groupMetrics.describe(ch)
+	childMetrics.describe(ch)
+	describeThreads(ch)
+	describeUp(ch)
+	describeTcpInfo(ch)
 }
 
 func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
-	listenProcess, err := listen_process.GetListenPortPid(uint32(e.listenPort))
-	if err != nil {
-		log.Printf("query listen port %d error: %v", e.listenPort, err)
+	e.collectScanStats(ch)
+	listenProcessList, results := e.discover(ch)
+	reasons := make(map[string]map[string]bool, len(listenProcessList))
+	defer func() {
+		e.collectUp(ch, results, reasons)
+	}()
+	if len(listenProcessList) == 0 {
 		return
 	}
-	if listenProcess.Pid == 0 {
-		log.Printf("not found listen port %d pid", e.listenPort)
-		return
+	if hasProtocol(listenProcessList, listen_process.ProtocolTCP) {
+		e.collectNetStat(ch)
+	}
+	e.collectConnections(ch, listenProcessList)
+	if collectTcpInfo {
+		e.collectTcpInfo(ch, listenProcessList)
+	}
+	var tree listen_process.ProcessTree
+	if e.collectChildProcess {
+		var err error
+		if tree, err = listen_process.LoadProcessTree(e.ctx); err != nil {
+			log.Printf("load process tree error: %v", err)
+		}
+	}
+	for _, listenProcess := range listen_process.RefreshSocketQueue(e.ctx, listenProcessList) {
+		reasons[listenProcess.Key()] = e.collectListenProcess(ch, listenProcess)
+		if e.collectChildProcess && len(listenProcess.Processes) > 0 {
+			e.collectChildren(ch, tree, listenProcess)
+		}
+	}
+}
+
+/*
+ *  @Description: listen process of every target, target without listen process is reported as not found
+ */
+func (e *Exporter) discover(ch chan<- prometheus.Metric) ([]listen_process.ListenProcess, []targetResult) {
+	var (
+		listenProcessList []listen_process.ListenProcess
+		results           []targetResult
+		targets           []listen_process.Target
+		// bare port and ip of the same port may find the same listen process
+		seen = make(map[string]struct{})
+	)
+	add := func(d listen_process.Discoverer, lps []listen_process.ListenProcess, err error) {
+		r := targetResult{discoverer: d, reasons: make(map[string]bool)}
+		defer func() {
+			results = append(results, r)
+		}()
+		if err != nil {
+			if errors.Is(err, listen_process.ErrNotFound) {
+				r.reasons[ReasonNotListening] = true
+				ch <- prometheus.MustNewConstMetric(notFoundDesc,
+					prometheus.GaugeValue, 1, d.String())
+			} else {
+				// exporter failed to look, the target may still be listening
+				r.reasons[readFailureReason(err)] = true
+			}
+			log.Printf("query %s error: %v", d, err)
+			return
+		}
+		for _, lp := range lps {
+			r.keys = append(r.keys, lp.Key())
+			if _, ok := seen[lp.Key()]; ok {
+				continue
+			}
+			seen[lp.Key()] = struct{}{}
+			listenProcessList = append(listenProcessList, lp)
+		}
+	}
+	for _, d := range e.discoverers {
+		if t, ok := d.(listen_process.Target); ok {
+			// ports of a range are resolved together
+			targets = append(targets, t)
+			continue
+		}
+		lps, err := d.Discover(e.ctx)
+		add(d, lps, err)
+	}
+	lpss, errs := listen_process.GetListenPortPids(e.ctx, targets)
+	for i, lps := range lpss {
+		add(targets[i], lps, errs[i])
+	}
+	return listenProcessList, results
+}
+
+func hasProtocol(listenProcessList []listen_process.ListenProcess, protocol string) bool {
+	for _, listenProcess := range listenProcessList {
+		if listenProcess.Protocol == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ *  @Description: collect connection count of tcp listen socket by state
+ */
+func (e *Exporter) collectConnections(ch chan<- prometheus.Metric, listenProcessList []listen_process.ListenProcess) {
+	connections := listen_process.CountConnections(e.ctx, listenProcessList, clientAggregation)
+	for _, listenProcess := range listenProcessList {
+		c, ok := connections[listenProcess.Key()]
+		if !ok {
+			continue
+		}
+		for _, state := range listen_process.ConnectionStates {
+			ch <- prometheus.MustNewConstMetric(connectionsDesc,
+				prometheus.GaugeValue, float64(c.States[state]),
+				socketLabelValues(listenProcess, state.String())...)
+		}
+		if c.Clients != nil {
+			e.collectClients(ch, listenProcess, c.Clients)
+		}
+	}
+}
+
+/*
+ *  @Description: collect stats of the last /proc/<pid>/fd scan
+ */
+func (e *Exporter) collectScanStats(ch chan<- prometheus.Metric) {
+	stats := listen_process.LastScanStats()
+	for _, reason := range []string{listen_process.SkipReasonTimeout, listen_process.SkipReasonHung,
+		listen_process.SkipReasonPermission, listen_process.SkipReasonError} {
+		ch <- prometheus.MustNewConstMetric(scanSkippedPidsDesc,
+			prometheus.GaugeValue, float64(stats.Skipped[reason]), reason)
 	}
-	processStats, err := collectProcessStat(context.Background(), listenProcess.Pid)
+	ch <- prometheus.MustNewConstMetric(scanHungDesc,
+		prometheus.GaugeValue, float64(stats.Hung))
+	ch <- prometheus.MustNewConstMetric(scanDurationDesc,
+		prometheus.GaugeValue, stats.Duration.Seconds())
+}
+
+/*
+ *  @Description: collect host wide listen overflows and drops
+ */
+func (e *Exporter) collectNetStat(ch chan<- prometheus.Metric) {
+	netStat, err := linuxproc.ReadNetStat(filepath.Join(comm.ProcDir(), "net", "netstat"))
 	if err != nil {
-		log.Printf("query listen port %d pid %d error: %v", e.listenPort, listenProcess.Pid, err)
+		if comm.Debug() {
+			log.Printf("collect netstat error %v", err)
+		}
 		return
 	}
+	ch <- prometheus.MustNewConstMetric(listenOverflowsDesc,
+		prometheus.CounterValue, float64(netStat.ListenOverflows))
+	ch <- prometheus.MustNewConstMetric(listenDropsDesc,
+		prometheus.CounterValue, float64(netStat.ListenDrops))
+}
+
+/*
+ *  @Description: collect metrics of one listen process, return reasons of scrape error
+ */
+func (e *Exporter) collectListenProcess(ch chan<- prometheus.Metric, listenProcess listen_process.ListenProcess) map[string]bool {
+	reasons := make(map[string]bool)
+	switch listenProcess.Protocol {
+	case listen_process.ProtocolUDP:
+		ch <- prometheus.MustNewConstMetric(socketRxQueueDesc,
+			prometheus.GaugeValue, float64(listenProcess.RxQueue),
+			socketLabelValues(listenProcess)...)
+		ch <- prometheus.MustNewConstMetric(socketDropsDesc,
+			prometheus.CounterValue, float64(listenProcess.Drops),
+			socketLabelValues(listenProcess)...)
+	case listen_process.ProtocolTCP:
+		ch <- prometheus.MustNewConstMetric(socketAcceptQueueDesc,
+			prometheus.GaugeValue, float64(listenProcess.AcceptQueue),
+			socketLabelValues(listenProcess)...)
+		// backlog is unknown without sock_diag
+		if listenProcess.Backlog > 0 {
+			ch <- prometheus.MustNewConstMetric(socketBacklogDesc,
+				prometheus.GaugeValue, float64(listenProcess.Backlog),
+				socketLabelValues(listenProcess)...)
+		}
+	}
+	if len(listenProcess.Processes) == 0 {
+		log.Printf("not found listen %s pid", listenProcess.Key())
+		reasons[ReasonPidUnresolved] = true
+		return reasons
+	}
+	var group groupStats
+	for _, p := range listenProcess.Processes {
+		processStats, err := collectProcessStat(e.ctx, p.Pid)
+		if err != nil {
+			log.Printf("query listen %s pid %d error: %v", listenProcess.Key(), p.Pid, err)
+			reasons[readFailureReason(err)] = true
+			if processStats.Stat == nil {
+				// process exited
+				continue
+			}
+		}
+		group.add(processStats)
+		e.collectProcess(ch, processStats, processLabelValues(listenProcess, p))
+		if collectThreads {
+			e.collectThreads(ch, listenProcess, p)
+		}
+	}
+	groupMetrics.collect(ch, group, socketLabelValues(listenProcess))
+	return reasons
+}
+
+/*
+ *  @Description: collect metrics of one process holding the listen socket
+ */
+func (e *Exporter) collectProcess(ch chan<- prometheus.Metric, processStats ProcessStats, labels []string) {
 	ch <- prometheus.MustNewConstMetric(startTimeDesc,
 		prometheus.GaugeValue, float64(processStats.Stat.Starttime),
-		listenPortToString(listenProcess.Port), listenProcessPIDToString(listenProcess.Pid))
+		labels...)
 
 	ch <- prometheus.MustNewConstMetric(numThreadDesc,
 		prometheus.GaugeValue, float64(processStats.Stat.NumThreads),
-		listenPortToString(listenProcess.Port), listenProcessPIDToString(listenProcess.Pid))
+		labels...)
 
 	ch <- prometheus.MustNewConstMetric(cpuSecsDesc,
 		prometheus.CounterValue, float64(processStats.Stat.Utime)/userHZ,
-		listenPortToString(listenProcess.Port), listenProcessPIDToString(listenProcess.Pid), "user")
+		withLabels(labels, "user")...)
 	ch <- prometheus.MustNewConstMetric(cpuSecsDesc,
 		prometheus.CounterValue, float64(processStats.Stat.Stime)/userHZ,
-		listenPortToString(listenProcess.Port), listenProcessPIDToString(listenProcess.Pid), "system")
-
-	ch <- prometheus.MustNewConstMetric(memBytesDesc,
-		prometheus.GaugeValue, float64(processStats.Status.VmRSS),
-		listenPortToString(listenProcess.Port), listenProcessPIDToString(listenProcess.Pid), "resident")
-	ch <- prometheus.MustNewConstMetric(memBytesDesc,
-		prometheus.GaugeValue, float64(processStats.Status.VmSize),
-		listenPortToString(listenProcess.Port), listenProcessPIDToString(listenProcess.Pid), "virtual")
-	ch <- prometheus.MustNewConstMetric(memBytesDesc,
-		prometheus.GaugeValue, float64(processStats.Status.VmSwap),
-		listenPortToString(listenProcess.Port), listenProcessPIDToString(listenProcess.Pid), "swapped")
+		withLabels(labels, "system")...)
+
+	collectMemory(ch, processStats, labels)
 
 	ch <- prometheus.MustNewConstMetric(readBytesDesc,
 		prometheus.CounterValue, float64(processStats.IO.ReadBytes),
-		listenPortToString(listenProcess.Port), listenProcessPIDToString(listenProcess.Pid))
+		labels...)
 	ch <- prometheus.MustNewConstMetric(readCallsDesc,
 		prometheus.CounterValue, float64(processStats.IO.Syscr),
-		listenPortToString(listenProcess.Port), listenProcessPIDToString(listenProcess.Pid))
+		labels...)
 
 	ch <- prometheus.MustNewConstMetric(writeBytesDesc,
 		prometheus.CounterValue, float64(processStats.IO.WriteBytes),
-		listenPortToString(listenProcess.Port), listenProcessPIDToString(listenProcess.Pid))
+		labels...)
 	ch <- prometheus.MustNewConstMetric(writeCallsDesc,
 		prometheus.CounterValue, float64(processStats.IO.Syscw),
-		listenPortToString(listenProcess.Port), listenProcessPIDToString(listenProcess.Pid))
+		labels...)
 
 	ch <- prometheus.MustNewConstMetric(majorPageFaultsDesc,
 		prometheus.CounterValue, float64(processStats.Stat.Majflt),
-		listenPortToString(listenProcess.Port), listenProcessPIDToString(listenProcess.Pid))
+		labels...)
 	ch <- prometheus.MustNewConstMetric(minorPageFaultsDesc,
 		prometheus.CounterValue, float64(processStats.Stat.Minflt),
-		listenPortToString(listenProcess.Port), listenProcessPIDToString(listenProcess.Pid))
+		labels...)
 
-	ch <- prometheus.MustNewConstMetric(contextSwitchesDesc,
-		prometheus.CounterValue, float64(processStats.Status.NonvoluntaryCtxtSwitches),
-		listenPortToString(listenProcess.Port), listenProcessPIDToString(listenProcess.Pid), "voluntary")
 	ch <- prometheus.MustNewConstMetric(contextSwitchesDesc,
 		prometheus.CounterValue, float64(processStats.Status.VoluntaryCtxtSwitches),
-		listenPortToString(listenProcess.Port), listenProcessPIDToString(listenProcess.Pid), "nonvoluntary")
+		withLabels(labels, "voluntary")...)
+	ch <- prometheus.MustNewConstMetric(contextSwitchesDesc,
+		prometheus.CounterValue, float64(processStats.Status.NonvoluntaryCtxtSwitches),
+		withLabels(labels, "nonvoluntary")...)
 
 	ch <- prometheus.MustNewConstMetric(openFDsDesc,
-		prometheus.GaugeValue, float64(processStats.Status.FDSize),
-		listenPortToString(listenProcess.Port), listenProcessPIDToString(listenProcess.Pid))
+		prometheus.GaugeValue, float64(processStats.FileDescCount),
+		labels...)
+
+}
 
+/*
+ *  @Description: label names of process metric, extra labels are appended
+ */
+func processLabelNames(extra ...string) []string {
+	return append(append(make([]string, 0, len(processLabels)+len(extra)), processLabels...), extra...)
+}
+
+/*
+ *  @Description: label values of process metric, in the order of processLabelNames
+ */
+func processLabelValues(lp listen_process.ListenProcess, p listen_process.SocketProcess, extra ...string) []string {
+	return append([]string{
+		listenPortLabel(lp),
+		listenProcessPIDToString(p.Pid),
+		lp.Protocol,
+		lp.Addr(),
+		lp.Path,
+		p.Role,
+		netnsToString(lp.Netns),
+		forwarderPIDLabel(lp),
+	}, extra...)
+}
+
+/*
+ *  @Description: copy label values and append extra labels
+ */
+func withLabels(labels []string, extra ...string) []string {
+	return append(append(make([]string, 0, len(labels)+len(extra)), labels...), extra...)
+}
+
+func socketLabelNames(extra ...string) []string {
+	return append(append(make([]string, 0, len(socketLabels)+len(extra)), socketLabels...), extra...)
+}
+
+func socketLabelValues(lp listen_process.ListenProcess, extra ...string) []string {
+	return append([]string{
+		listenPortLabel(lp),
+		lp.Protocol,
+		lp.Addr(),
+		netnsToString(lp.Netns),
+	}, extra...)
+}
+
+/*
+ *  @Description: unix socket and process found without socket have no port, leave the label empty
+ */
+func listenPortLabel(lp listen_process.ListenProcess) string {
+	if lp.Protocol != listen_process.ProtocolTCP && lp.Protocol != listen_process.ProtocolUDP {
+		return ""
+	}
+	return listenPortToString(lp.Port)
+}
+
+/*
+ *  @Description: empty when the listen socket is not held by a forwarder
+ */
+func forwarderPIDLabel(lp listen_process.ListenProcess) string {
+	if lp.ForwarderPid == 0 {
+		return ""
+	}
+	return listenProcessPIDToString(lp.ForwarderPid)
 }
 
 func listenPortToString(p uint32) string {
@@ -191,3 +526,7 @@ func listenPortToString(p uint32) string {
 func listenProcessPIDToString(p int32) string {
 	return strconv.FormatInt(int64(p), 10)
 }
+
+func netnsToString(netns uint64) string {
+	return strconv.FormatUint(netns, 10)
+}
@@ -0,0 +1,130 @@
+// Package exporter
+// @Description: aggregate processes sharing listen socket
+package exporter
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+ *  @Description: metrics of a group of processes, such as processes holding the listen socket or their children
+ */
+type groupDescs struct {
+	numProcs   *prometheus.Desc
+	cpuSecs    *prometheus.Desc
+	memBytes   *prometheus.Desc
+	readBytes  *prometheus.Desc
+	writeBytes *prometheus.Desc
+	openFDs    *prometheus.Desc
+	numThreads *prometheus.Desc
+}
+
+var (
+	groupMetrics = newGroupDescs("listen_port_group_", "num_procs", "processes holding the listen socket")
+)
+
+/*
+ * @Description: descs of group metrics
+ * @Param prefix: prefix of metric name
+ * @Param numProcs: name of the process count
+ * @Param group: description of processes in help
+ * @Param extra: extra labels after socket labels
+ * @Return groupDescs:
+ */
+func newGroupDescs(prefix string, numProcs string, group string, extra ...string) groupDescs {
+	labels := func(more ...string) []string {
+		return socketLabelNames(append(append([]string{}, extra...), more...)...)
+	}
+	return groupDescs{
+		numProcs: prometheus.NewDesc(prefix+numProcs,
+			"number of "+group,
+			labels(), nil),
+		cpuSecs: prometheus.NewDesc(prefix+"cpu_seconds_total",
+			"Cpu usage in seconds of "+group,
+			labels("mode"), nil),
+		memBytes: prometheus.NewDesc(prefix+"memory_bytes",
+			"number of bytes of memory in use by "+group,
+			labels("memory_type"), nil),
+		readBytes: prometheus.NewDesc(prefix+"read_bytes_total",
+			"number of bytes read by "+group,
+			labels(), nil),
+		writeBytes: prometheus.NewDesc(prefix+"write_bytes_total",
+			"number of bytes written by "+group,
+			labels(), nil),
+		openFDs: prometheus.NewDesc(prefix+"open_file_desc",
+			"number of open file descriptors of "+group,
+			labels(), nil),
+		numThreads: prometheus.NewDesc(prefix+"thread_count",
+			"number of threads of "+group,
+			labels(), nil),
+	}
+}
+
+/*
+ *  @Description: sum of process stats
+ */
+type groupStats struct {
+	numProcs   int
+	utime      uint64
+	stime      uint64
+	resident   uint64
+	virtual    uint64
+	swapped    uint64
+	readBytes  uint64
+	writeBytes uint64
+	openFDs    uint64
+	numThreads int64
+}
+
+func (g *groupStats) add(processStats ProcessStats) {
+	g.numProcs++
+	g.utime += processStats.Stat.Utime
+	g.stime += processStats.Stat.Stime
+	g.resident += processStats.Memory.VmRSS
+	g.virtual += processStats.Memory.VmSize
+	g.swapped += processStats.Memory.VmSwap
+	g.readBytes += processStats.IO.ReadBytes
+	g.writeBytes += processStats.IO.WriteBytes
+	g.openFDs += uint64(processStats.FileDescCount)
+	g.numThreads += processStats.Stat.NumThreads
+}
+
+func (d groupDescs) describe(ch chan<- *prometheus.Desc) {
+	ch <- d.numProcs
+	ch <- d.cpuSecs
+	ch <- d.memBytes
+	ch <- d.readBytes
+	ch <- d.writeBytes
+	ch <- d.openFDs
+	ch <- d.numThreads
+}
+
+/*
+ *  @Description: collect aggregated metrics of a group of processes
+ */
+func (d groupDescs) collect(ch chan<- prometheus.Metric, g groupStats, labels []string) {
+	ch <- prometheus.MustNewConstMetric(d.numProcs,
+		prometheus.GaugeValue, float64(g.numProcs), labels...)
+
+	ch <- prometheus.MustNewConstMetric(d.cpuSecs,
+		prometheus.CounterValue, float64(g.utime)/userHZ, withLabels(labels, "user")...)
+	ch <- prometheus.MustNewConstMetric(d.cpuSecs,
+		prometheus.CounterValue, float64(g.stime)/userHZ, withLabels(labels, "system")...)
+
+	ch <- prometheus.MustNewConstMetric(d.memBytes,
+		prometheus.GaugeValue, float64(g.resident), withLabels(labels, "resident")...)
+	ch <- prometheus.MustNewConstMetric(d.memBytes,
+		prometheus.GaugeValue, float64(g.virtual), withLabels(labels, "virtual")...)
+	ch <- prometheus.MustNewConstMetric(d.memBytes,
+		prometheus.GaugeValue, float64(g.swapped), withLabels(labels, "swapped")...)
+
+	ch <- prometheus.MustNewConstMetric(d.readBytes,
+		prometheus.CounterValue, float64(g.readBytes), labels...)
+	ch <- prometheus.MustNewConstMetric(d.writeBytes,
+		prometheus.CounterValue, float64(g.writeBytes), labels...)
+
+	ch <- prometheus.MustNewConstMetric(d.openFDs,
+		prometheus.GaugeValue, float64(g.openFDs), labels...)
+	ch <- prometheus.MustNewConstMetric(d.numThreads,
+		prometheus.GaugeValue, float64(g.numThreads), labels...)
+}
@@ -0,0 +1,129 @@
+// Package exporter
+// @Description: memory breakdown of process from /proc/<pid>/status
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	memPeakBytesDesc = prometheus.NewDesc(
+		"listen_port_process_memory_peak_bytes",
+		"peak number of bytes of memory in use since the process started",
+		processLabelNames("memory_type"), nil)
+)
+
+/*
+ *  @Description: memory fields of /proc/<pid>/status in bytes, fields missing on old kernel are 0
+ */
+type MemoryStats struct {
+	VmPeak   uint64 `json:"vm_peak"`
+	VmSize   uint64 `json:"vm_size"`
+	VmHWM    uint64 `json:"vm_hwm"`
+	VmRSS    uint64 `json:"vm_rss"`
+	RssAnon  uint64 `json:"rss_anon"`  // since linux 4.5
+	RssFile  uint64 `json:"rss_file"`  // since linux 4.5
+	RssShmem uint64 `json:"rss_shmem"` // since linux 4.5
+	VmData   uint64 `json:"vm_data"`
+	VmStk    uint64 `json:"vm_stk"`
+	VmLib    uint64 `json:"vm_lib"`
+	VmPTE    uint64 `json:"vm_pte"`
+	VmSwap   uint64 `json:"vm_swap"`
+}
+
+/*
+ * @Description: read memory of /proc/<pid>/status, goprocinfo has neither Rss* fields nor the kB unit
+ * @Param path: /proc/<pid>/status
+ * @Return *MemoryStats:
+ * @Return error:
+ */
+func readMemoryStatus(path string) (*MemoryStats, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseMemoryStatus(b), nil
+}
+
+func parseMemoryStatus(b []byte) *MemoryStats {
+	stats := &MemoryStats{}
+	fields := map[string]*uint64{
+		"VmPeak:":   &stats.VmPeak,
+		"VmSize:":   &stats.VmSize,
+		"VmHWM:":    &stats.VmHWM,
+		"VmRSS:":    &stats.VmRSS,
+		"RssAnon:":  &stats.RssAnon,
+		"RssFile:":  &stats.RssFile,
+		"RssShmem:": &stats.RssShmem,
+		"VmData:":   &stats.VmData,
+		"VmStk:":    &stats.VmStk,
+		"VmLib:":    &stats.VmLib,
+		"VmPTE:":    &stats.VmPTE,
+		"VmSwap:":   &stats.VmSwap,
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		// such as "VmRSS:	    1234 kB"
+		f := bytes.Fields(scanner.Bytes())
+		if len(f) != 3 || string(f[2]) != "kB" {
+			continue
+		}
+		field, ok := fields[string(f[0])]
+		if !ok {
+			continue
+		}
+		if kb, err := strconv.ParseUint(string(f[1]), 10, 64); err == nil {
+			*field = kb * 1024
+		}
+	}
+	return stats
+}
+
+/*
+ *  @Description: collect memory in bytes of one process holding the listen socket
+ */
+func collectMemory(ch chan<- prometheus.Metric, processStats ProcessStats, labels []string) {
+	m := processStats.Memory
+	for _, v := range []struct {
+		memoryType string
+		bytes      uint64
+	}{
+		{"resident", m.VmRSS},
+		{"virtual", m.VmSize},
+		{"swapped", m.VmSwap},
+		{"residentAnon", m.RssAnon},
+		{"residentFile", m.RssFile},
+		{"residentShmem", m.RssShmem},
+		{"data", m.VmData},
+		{"stack", m.VmStk},
+		{"lib", m.VmLib},
+		{"pageTables", m.VmPTE},
+	} {
+		ch <- prometheus.MustNewConstMetric(memBytesDesc,
+			prometheus.GaugeValue, float64(v.bytes), withLabels(labels, v.memoryType)...)
+	}
+	ch <- prometheus.MustNewConstMetric(memPeakBytesDesc,
+		prometheus.GaugeValue, float64(m.VmHWM), withLabels(labels, "resident")...)
+	ch <- prometheus.MustNewConstMetric(memPeakBytesDesc,
+		prometheus.GaugeValue, float64(m.VmPeak), withLabels(labels, "virtual")...)
+
+	if processStats.Smaps != nil {
+		ch <- prometheus.MustNewConstMetric(memBytesDesc,
+			prometheus.GaugeValue, float64(processStats.Smaps.Pss),
+			withLabels(labels, "proportionalResident")...)
+		ch <- prometheus.MustNewConstMetric(memBytesDesc,
+			prometheus.GaugeValue, float64(processStats.Smaps.Uss),
+			withLabels(labels, "uniqueResident")...)
+		ch <- prometheus.MustNewConstMetric(memBytesDesc,
+			prometheus.GaugeValue, float64(processStats.Smaps.SwapPss),
+			withLabels(labels, "proportionalSwapped")...)
+		ch <- prometheus.MustNewConstMetric(memBytesDesc,
+			prometheus.GaugeValue, float64(processStats.Smaps.AnonHugePages),
+			withLabels(labels, "anonHugePages")...)
+	}
+}
@@ -0,0 +1,87 @@
+// Package exporter
+// @Description: proportional and unique memory of process from smaps
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+var (
+	// disabled by default, reading smaps takes the mmap lock of the process
+	collectSmaps = false
+)
+
+/*
+ *  @Description: memory summed over all mappings of process, in bytes
+ */
+type SmapsStats struct {
+	Pss           uint64 `json:"pss"`
+	Uss           uint64 `json:"uss"` // Private_Clean + Private_Dirty
+	SwapPss       uint64 `json:"swap_pss"`
+	AnonHugePages uint64 `json:"anon_huge_pages"`
+}
+
+/*
+ *  @Description: enable pss, uss, swap pss and anon huge pages from /proc/<pid>/smaps_rollup
+ */
+func SetSmaps(enable bool) {
+	collectSmaps = enable
+	log.Printf("set collect smaps = %v", enable)
+}
+
+/*
+ * @Description: read smaps_rollup of process, or sum every mapping of smaps when smaps_rollup is missing before linux 4.14
+ * @Param dir: /proc/<pid>
+ * @Return *SmapsStats:
+ * @Return error:
+ */
+func readSmaps(dir string) (*SmapsStats, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "smaps_rollup"))
+	if os.IsNotExist(err) {
+		b, err = ioutil.ReadFile(filepath.Join(dir, "smaps"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseSmaps(b), nil
+}
+
+/*
+ *  @Description: sum fields of smaps, smaps_rollup has the same format with one mapping
+ */
+func parseSmaps(b []byte) *SmapsStats {
+	stats := &SmapsStats{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		// such as "Pss:                 123 kB", mapping header and VmFlags have no kB
+		f := bytes.Fields(scanner.Bytes())
+		if len(f) != 3 || string(f[2]) != "kB" {
+			continue
+		}
+		var field *uint64
+		switch string(f[0]) {
+		case "Pss:":
+			field = &stats.Pss
+		case "Private_Clean:", "Private_Dirty:":
+			field = &stats.Uss
+		case "SwapPss:":
+			field = &stats.SwapPss
+		case "AnonHugePages:":
+			field = &stats.AnonHugePages
+		default:
+			continue
+		}
+		kb, err := strconv.ParseUint(string(f[1]), 10, 64)
+		if err != nil {
+			continue
+		}
+		*field += kb * 1024
+	}
+	return stats
+}
@@ -0,0 +1,91 @@
+// Package exporter
+// @Description: tcp_info of connections accepted by listen socket
+package exporter
+
+import (
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"listen_process_exporter/comm"
+	"listen_process_exporter/listen_process"
+)
+
+var (
+	collectTcpInfo = false
+
+	// rtt buckets in seconds, from 100us to 1s
+	tcpRttBuckets = []float64{.0001, .00025, .0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1}
+
+	tcpRttDesc = prometheus.NewDesc(
+		"listen_port_tcp_rtt_seconds",
+		"smoothed rtt of tcp connections accepted by listen socket",
+		socketLabelNames(), nil)
+
+	tcpRetransmitsDesc = prometheus.NewDesc(
+		"listen_port_tcp_retransmits",
+		"sum of retransmitted segments of current tcp connections accepted by listen socket",
+		socketLabelNames(), nil)
+
+	tcpBytesAckedDesc = prometheus.NewDesc(
+		"listen_port_tcp_bytes_acked",
+		"sum of bytes acked of current tcp connections accepted by listen socket",
+		socketLabelNames(), nil)
+
+	tcpBytesReceivedDesc = prometheus.NewDesc(
+		"listen_port_tcp_bytes_received",
+		"sum of bytes received of current tcp connections accepted by listen socket",
+		socketLabelNames(), nil)
+)
+
+/*
+ *  @Description: enable tcp_info of connections through NETLINK_SOCK_DIAG
+ */
+func SetTcpInfo(enable bool) {
+	collectTcpInfo = enable
+	log.Printf("set collect tcp info = %v", enable)
+}
+
+func describeTcpInfo(ch chan<- *prometheus.Desc) {
+	ch <- tcpRttDesc
+	ch <- tcpRetransmitsDesc
+	ch <- tcpBytesAckedDesc
+	ch <- tcpBytesReceivedDesc
+}
+
+/*
+ *  @Description: collect tcp_info of connections accepted by listen sockets
+ */
+func (e *Exporter) collectTcpInfo(ch chan<- prometheus.Metric, listenProcessList []listen_process.ListenProcess) {
+	stats, err := listen_process.CollectTcpInfo(listenProcessList)
+	if err != nil {
+		if comm.Debug() {
+			log.Printf("collect tcp info error %v", err)
+		}
+		return
+	}
+	for _, listenProcess := range listenProcessList {
+		s, ok := stats[listenProcess.Key()]
+		if !ok {
+			continue
+		}
+		labels := socketLabelValues(listenProcess)
+		buckets := make(map[float64]uint64, len(tcpRttBuckets))
+		var sum float64
+		for _, rtt := range s.RTTs {
+			sum += rtt
+			for _, b := range tcpRttBuckets {
+				if rtt <= b {
+					buckets[b]++
+				}
+			}
+		}
+		ch <- prometheus.MustNewConstHistogram(tcpRttDesc,
+			uint64(len(s.RTTs)), sum, buckets, labels...)
+		ch <- prometheus.MustNewConstMetric(tcpRetransmitsDesc,
+			prometheus.GaugeValue, float64(s.Retransmits), labels...)
+		ch <- prometheus.MustNewConstMetric(tcpBytesAckedDesc,
+			prometheus.GaugeValue, float64(s.BytesAcked), labels...)
+		ch <- prometheus.MustNewConstMetric(tcpBytesReceivedDesc,
+			prometheus.GaugeValue, float64(s.BytesReceived), labels...)
+	}
+}
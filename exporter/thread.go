@@ -0,0 +1,197 @@
+// Package exporter
+// @Description: per thread cpu and state of process holding listen socket
+package exporter
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	linuxproc "github.com/c9s/goprocinfo/linux"
+	"github.com/prometheus/client_golang/prometheus"
+	"listen_process_exporter/comm"
+	"listen_process_exporter/listen_process"
+)
+
+const (
+	threadName  = "thread_name"
+	threadState = "state"
+)
+
+/*
+ *  @Description: thread whose name matches the regex is reported as the name, such as connection.* to connection
+ */
+type threadNameRule struct {
+	re   *regexp.Regexp
+	name string
+}
+
+var (
+	// disabled by default, reading every thread is expensive for process with many threads
+	collectThreads  = false
+	threadNameRules []threadNameRule
+	// scheduler states always reported, other states such as T and I are reported when found
+	threadStates = []string{"R", "S", "D", "Z"}
+
+	threadCpuSecsDesc = prometheus.NewDesc(
+		"listen_port_thread_cpu_seconds_total",
+		"Cpu user and system usage in seconds of threads with the same name",
+		processLabelNames(threadName, "mode"), nil)
+
+	threadCtxSwitchDesc = prometheus.NewDesc(
+		"listen_port_thread_context_switches_total",
+		"Context switches of threads with the same name",
+		processLabelNames(threadName, "ctx_switch_type"), nil)
+
+	threadReadBytesDesc = prometheus.NewDesc(
+		"listen_port_thread_read_bytes_total",
+		"number of bytes read by threads with the same name",
+		processLabelNames(threadName), nil)
+
+	threadWriteBytesDesc = prometheus.NewDesc(
+		"listen_port_thread_write_bytes_total",
+		"number of bytes written by threads with the same name",
+		processLabelNames(threadName), nil)
+
+	threadStateDesc = prometheus.NewDesc(
+		"listen_port_thread_state_count",
+		"number of threads in the scheduler state, such as R S D Z",
+		processLabelNames(threadState), nil)
+)
+
+/*
+ * @Description: enable per thread metrics
+ * @Param enable:
+ * @Param rules: normalize thread name, such as connection.*=connection, the first matching rule wins
+ * @Return error:
+ */
+func SetThreadCollector(enable bool, rules []string) error {
+	var parsed []threadNameRule
+	for _, rule := range rules {
+		// name never contains =, regex may
+		i := strings.LastIndex(rule, "=")
+		if i <= 0 || i == len(rule)-1 {
+			return fmt.Errorf("thread name rule[%s] must be regex=name", rule)
+		}
+		re, err := regexp.Compile("^(?:" + rule[:i] + ")$")
+		if err != nil {
+			return fmt.Errorf("thread name rule[%s] invalid: %v", rule, err)
+		}
+		parsed = append(parsed, threadNameRule{re: re, name: rule[i+1:]})
+	}
+	collectThreads = enable
+	threadNameRules = parsed
+	if enable {
+		log.Printf("enable thread collector with %d name rules", len(parsed))
+	}
+	return nil
+}
+
+func describeThreads(ch chan<- *prometheus.Desc) {
+	ch <- threadCpuSecsDesc
+	ch <- threadCtxSwitchDesc
+	ch <- threadReadBytesDesc
+	ch <- threadWriteBytesDesc
+	ch <- threadStateDesc
+}
+
+/*
+ *  @Description: sum of threads with the same name
+ */
+type threadStats struct {
+	utime        uint64
+	stime        uint64
+	voluntary    uint64
+	nonvoluntary uint64
+	readBytes    uint64
+	writeBytes   uint64
+}
+
+/*
+ *  @Description: normalized thread name
+ */
+func normalizeThreadName(name string) string {
+	for _, r := range threadNameRules {
+		if r.re.MatchString(name) {
+			return r.name
+		}
+	}
+	return name
+}
+
+/*
+ *  @Description: collect metrics of threads in /proc/<pid>/task aggregated by name, and thread count by state
+ */
+func (e *Exporter) collectThreads(ch chan<- prometheus.Metric, listenProcess listen_process.ListenProcess, p listen_process.SocketProcess) {
+	taskDir := filepath.Join(comm.ProcDir(), listenProcessPIDToString(p.Pid), "task")
+	tids, err := fileDescriptors(taskDir)
+	if err != nil {
+		if comm.Debug() {
+			log.Printf("read threads of pid %d error %v", p.Pid, err)
+		}
+		return
+	}
+	byName := make(map[string]*threadStats)
+	states := make(map[string]int)
+	for _, s := range threadStates {
+		states[s] = 0
+	}
+	for _, tid := range tids {
+		dir := filepath.Join(taskDir, tid)
+		stat, err := linuxproc.ReadProcessStat(filepath.Join(dir, "stat"))
+		if err != nil {
+			// thread exited
+			continue
+		}
+		states[stat.State]++
+		name := normalizeThreadName(strings.TrimSuffix(strings.TrimPrefix(stat.Comm, "("), ")"))
+		t, ok := byName[name]
+		if !ok {
+			t = &threadStats{}
+			byName[name] = t
+		}
+		t.utime += stat.Utime
+		t.stime += stat.Stime
+		if status, err := linuxproc.ReadProcessStatus(filepath.Join(dir, "status")); err == nil {
+			t.voluntary += status.VoluntaryCtxtSwitches
+			t.nonvoluntary += status.NonvoluntaryCtxtSwitches
+		}
+		// io of thread is unreadable without CAP_SYS_PTRACE
+		if io, err := linuxproc.ReadProcessIO(filepath.Join(dir, "io")); err == nil {
+			t.readBytes += io.ReadBytes
+			t.writeBytes += io.WriteBytes
+		} else if comm.Debug() && !os.IsNotExist(err) {
+			log.Printf("read io of thread %s of pid %d error %v", tid, p.Pid, err)
+		}
+	}
+
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	labels := processLabelValues(listenProcess, p)
+	for _, name := range names {
+		t := byName[name]
+		ch <- prometheus.MustNewConstMetric(threadCpuSecsDesc,
+			prometheus.CounterValue, float64(t.utime)/userHZ, withLabels(labels, name, "user")...)
+		ch <- prometheus.MustNewConstMetric(threadCpuSecsDesc,
+			prometheus.CounterValue, float64(t.stime)/userHZ, withLabels(labels, name, "system")...)
+		ch <- prometheus.MustNewConstMetric(threadCtxSwitchDesc,
+			prometheus.CounterValue, float64(t.voluntary), withLabels(labels, name, "voluntary")...)
+		ch <- prometheus.MustNewConstMetric(threadCtxSwitchDesc,
+			prometheus.CounterValue, float64(t.nonvoluntary), withLabels(labels, name, "nonvoluntary")...)
+		ch <- prometheus.MustNewConstMetric(threadReadBytesDesc,
+			prometheus.CounterValue, float64(t.readBytes), withLabels(labels, name)...)
+		ch <- prometheus.MustNewConstMetric(threadWriteBytesDesc,
+			prometheus.CounterValue, float64(t.writeBytes), withLabels(labels, name)...)
+	}
+	for state, n := range states {
+		ch <- prometheus.MustNewConstMetric(threadStateDesc,
+			prometheus.GaugeValue, float64(n), withLabels(labels, state)...)
+	}
+}
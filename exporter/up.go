@@ -0,0 +1,86 @@
+// Package exporter
+// @Description: whether the target is up and why the scrape failed
+package exporter
+
+import (
+	"errors"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"listen_process_exporter/listen_process"
+)
+
+const (
+	ReasonNotListening     = "not_listening"     // no listen socket or process of target
+	ReasonPidUnresolved    = "pid_unresolved"    // listen socket found but no process holding it
+	ReasonProcReadFailed   = "proc_read_failed"  // failed to read /proc of target or its processes
+	ReasonPermissionDenied = "permission_denied" // /proc of the process is not readable
+)
+
+var (
+	scrapeErrorReasons = []string{ReasonNotListening, ReasonPidUnresolved, ReasonProcReadFailed, ReasonPermissionDenied}
+
+	upDesc = prometheus.NewDesc(
+		"listen_port_process_up",
+		"whether the listen socket or process of target is found, 1 for up and 0 for down",
+		[]string{probeTarget}, nil)
+
+	scrapeErrorDesc = prometheus.NewDesc(
+		"listen_port_process_scrape_error",
+		"whether the scrape of target failed for the reason",
+		[]string{probeTarget, "reason"}, nil)
+)
+
+/*
+ *  @Description: listen process found by one discoverer
+ */
+type targetResult struct {
+	discoverer listen_process.Discoverer
+	keys       []string // key of listen process
+	reasons    map[string]bool
+}
+
+func describeUp(ch chan<- *prometheus.Desc) {
+	ch <- upDesc
+	ch <- scrapeErrorDesc
+}
+
+/*
+ * @Description: collect up and scrape error of every target, both are always reported
+ * @Param ch:
+ * @Param results: result of every target
+ * @Param reasons: scrape error of listen process by key
+ */
+func (e *Exporter) collectUp(ch chan<- prometheus.Metric, results []targetResult, reasons map[string]map[string]bool) {
+	for _, r := range results {
+		up := 0.0
+		if len(r.keys) > 0 {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(upDesc,
+			prometheus.GaugeValue, up, r.discoverer.String())
+		for _, key := range r.keys {
+			for reason := range reasons[key] {
+				r.reasons[reason] = true
+			}
+		}
+		for _, reason := range scrapeErrorReasons {
+			v := 0.0
+			if r.reasons[reason] {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(scrapeErrorDesc,
+				prometheus.GaugeValue, v, r.discoverer.String(), reason)
+		}
+	}
+}
+
+/*
+ *  @Description: reason of failure to read /proc
+ */
+func readFailureReason(err error) string {
+	if errors.Is(err, os.ErrPermission) {
+		return ReasonPermissionDenied
+	}
+	return ReasonProcReadFailed
+}
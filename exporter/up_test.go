@@ -0,0 +1,92 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"listen_process_exporter/listen_process"
+)
+
+/*
+ *  @Description: discoverer failing with the error
+ */
+type errDiscoverer struct {
+	name string
+	err  error
+}
+
+func (d errDiscoverer) Discover(ctx context.Context) ([]listen_process.ListenProcess, error) {
+	return nil, d.err
+}
+
+func (d errDiscoverer) String() string {
+	return d.name
+}
+
+/*
+ *  @Description: value of up and scrape error of every target, such as up{x} and not_listening{x}
+ */
+func collectUpValues(t *testing.T, discoverers ...listen_process.Discoverer) map[string]float64 {
+	t.Helper()
+	e := NewExporter(context.Background(), false, discoverers)
+	ch := make(chan prometheus.Metric, 100)
+	_, results := e.discover(ch)
+	e.collectUp(ch, results, nil)
+	close(ch)
+	values := make(map[string]float64)
+	for m := range ch {
+		var pb dto.Metric
+		if err := m.Write(&pb); err != nil {
+			t.Fatal(err)
+		}
+		var labels []string
+		for _, l := range pb.GetLabel() {
+			labels = append(labels, l.GetValue())
+		}
+		switch m.Desc() {
+		case upDesc:
+			values["up{"+labels[0]+"}"] = pb.GetGauge().GetValue()
+		case scrapeErrorDesc:
+			// labels are sorted by name: reason, target
+			values[labels[0]+"{"+labels[1]+"}"] = pb.GetGauge().GetValue()
+		}
+	}
+	return values
+}
+
+func TestCollectUpReasons(t *testing.T) {
+	pidfile, err := listen_process.ParseDiscoverer("pidfile:/nonexistent/x.pid", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := collectUpValues(t,
+		pidfile,
+		errDiscoverer{name: "denied", err: fmt.Errorf("read pidfile: %w", os.ErrPermission)},
+		errDiscoverer{name: "broken", err: errors.New("read /proc/net/tcp: input/output error")},
+	)
+	want := map[string]string{
+		"pidfile:/nonexistent/x.pid": ReasonNotListening,
+		"denied":                     ReasonPermissionDenied,
+		"broken":                     ReasonProcReadFailed,
+	}
+	for target, reason := range want {
+		if v := values["up{"+target+"}"]; v != 0 {
+			t.Errorf("up{%s} = %v, want 0", target, v)
+		}
+		// exactly one reason, only not found is not listening
+		for _, r := range scrapeErrorReasons {
+			expect := 0.0
+			if r == reason {
+				expect = 1
+			}
+			if v, ok := values[r+"{"+target+"}"]; !ok || v != expect {
+				t.Errorf("scrape error %s{%s} = %v, want %v", r, target, v, expect)
+			}
+		}
+	}
+}
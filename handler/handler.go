@@ -7,12 +7,10 @@
 package handler
 
 import (
-	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -29,14 +27,19 @@ func HandleProbe(collectChildProcess bool) http.HandlerFunc {
 			http.Error(w, "target is required", http.StatusBadRequest)
 			return
 		}
-		listenPort, err := strconv.Atoi(target)
+		netns, err := listen_process.ParseNetns(params.Get("netns"))
 		if err != nil {
-			http.Error(w, fmt.Sprintf("target[%s] must be number", target), http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		discoverers, err := listen_process.ParseDiscoverers(target, netns)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("target[%s] invalid: %v", target, err), http.StatusBadRequest)
 			return
 		}
 		registry := prometheus.NewRegistry()
 
-		registry.MustRegister(exporter.NewExporter(collectChildProcess, listenPort))
+		registry.MustRegister(exporter.NewExporter(r.Context(), collectChildProcess, discoverers))
 
 		h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
 		h.ServeHTTP(w, r)
@@ -51,7 +54,7 @@ func HandleRefreshListenProcess() http.HandlerFunc {
 		if comm.Debug() {
 			log.Printf("refresh listen process through http request %s", r.RemoteAddr)
 		}
-		listenProcess, err := listen_process.RefreshListenProcess(context.TODO())
+		listenProcess, err := listen_process.RefreshListenProcess(r.Context())
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			log.Printf("refresh listen process fail: %v", err)
@@ -0,0 +1,43 @@
+package listen_process
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestDecodeAddress(t *testing.T) {
+	tests := []struct {
+		family uint32
+		src    string
+		want   Addr
+		err    bool
+	}{
+		{family: syscall.AF_INET, src: "0500000A:0016", want: Addr{IP: "10.0.0.5", Port: 22}},
+		{family: syscall.AF_INET, src: "0100007F:0CEA", want: Addr{IP: "127.0.0.1", Port: 3306}},
+		{family: syscall.AF_INET, src: "00000000:0035", want: Addr{IP: "0.0.0.0", Port: 53}},
+		{family: syscall.AF_INET6, src: "0085002452100113070057A13F025401:0035",
+			want: Addr{IP: "2400:8500:1301:1052:a157:7:154:23f", Port: 53}},
+		{family: syscall.AF_INET6, src: "00000000000000000000000001000000:0CEA", want: Addr{IP: "::1", Port: 3306}},
+		{family: syscall.AF_INET6, src: "00000000000000000000000000000000:1F90", want: Addr{IP: "::", Port: 8080}},
+		{family: syscall.AF_INET, src: "0100007F", err: true},
+		{family: syscall.AF_INET, src: "0100007F:XYZ", err: true},
+		{family: syscall.AF_INET, src: "0100007G:0CEA", err: true},
+		{family: syscall.AF_INET6, src: "0100007F:0CEA", err: true},
+	}
+	for _, tt := range tests {
+		got, err := decodeAddress(tt.family, tt.src)
+		if tt.err {
+			if err == nil {
+				t.Errorf("decodeAddress(%s) = %v, want error", tt.src, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("decodeAddress(%s) error: %v", tt.src, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("decodeAddress(%s) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
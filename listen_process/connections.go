@@ -0,0 +1,138 @@
+// Package listen_process
+// @Description: count connections of listen socket at scrape time
+package listen_process
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"syscall"
+)
+
+const (
+	ClientLoopback = "loopback"
+	ClientExternal = "external"
+)
+
+/*
+ *  @Description: connections accepted by listen socket
+ */
+type Connections struct {
+	States  map[TcpState]int
+	Clients map[Client]int // only when client aggregation is enabled
+}
+
+/*
+ *  @Description: remote ip or network of connection
+ */
+type Client struct {
+	Addr string // ip, or network such as 10.0.0.0/24
+	Type string // loopback or external
+}
+
+/*
+ *  @Description: how to aggregate remote address of connection
+ */
+type ClientAggregation struct {
+	IPv4Prefix int // 32 means every ip
+	IPv6Prefix int // 128 means every ip
+}
+
+/*
+ * @Description: count tcp connections accepted by listen sockets
+ * @Param ctx: socket tables shared by WithSocketTables are not read again
+ * @Param lps: listen process
+ * @Param clients: aggregate connections by remote address, nil to disable
+ * @Return map[string]*Connections: connections by key of listen process
+ */
+func CountConnections(ctx context.Context, lps []ListenProcess, clients *ClientAggregation) map[string]*Connections {
+	wanted := make(map[string]int, len(lps))
+	ret := make(map[string]*Connections, len(lps))
+	for i, lp := range lps {
+		if lp.Protocol != ProtocolTCP {
+			continue
+		}
+		wanted[lp.Key()] = i
+		c := &Connections{States: make(map[TcpState]int)}
+		if clients != nil {
+			c.Clients = make(map[Client]int)
+		}
+		ret[lp.Key()] = c
+	}
+	if len(wanted) == 0 {
+		return ret
+	}
+	for netns, dir := range netDirs(lps) {
+		netns := netns
+		scanSocketTable(ctx, dir, map[string]bool{ProtocolTCP: true}, false, func(nf netFile, sl socketLine) {
+			i, ok := connectionListener(wanted, netns, nf, sl.local)
+			if !ok {
+				return
+			}
+			c := ret[lps[i].Key()]
+			c.States[sl.state]++
+			if clients == nil {
+				return
+			}
+			remote, err := sl.remoteAddress(nf.family)
+			if err != nil {
+				return
+			}
+			c.Clients[clients.client(remote.IP)]++
+		})
+	}
+	return ret
+}
+
+/*
+ * @Description: aggregate remote ip to its network
+ * @Param ip: remote ip
+ * @Return Client:
+ */
+func (a *ClientAggregation) client(ip string) Client {
+	c := Client{Addr: ip, Type: ClientExternal}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return c
+	}
+	if parsed.IsLoopback() {
+		c.Type = ClientLoopback
+	}
+	bits, prefix := 128, a.IPv6Prefix
+	if v4 := parsed.To4(); v4 != nil {
+		parsed, bits, prefix = v4, 32, a.IPv4Prefix
+	}
+	if prefix <= 0 || prefix >= bits {
+		c.Addr = parsed.String()
+		return c
+	}
+	c.Addr = parsed.Mask(net.CIDRMask(prefix, bits)).String() + "/" + strconv.Itoa(prefix)
+	return c
+}
+
+/*
+ * @Description: find listen socket which accepted the connection
+ *               listen socket bound to the local ip first, then the one bound to any address
+ * @Param wanted: index of listen process by key
+ * @Param netns: network namespace of the connection
+ * @Param nf: socket table of the connection
+ * @Param local: local address of the connection
+ * @Return int: index of listen process
+ * @Return bool: found
+ */
+func connectionListener(wanted map[string]int, netns uint64, nf netFile, local Addr) (int, bool) {
+	if i, ok := wanted[ListenProcessKey(netns, nf.protocol, local.IP, local.Port)]; ok {
+		return i, true
+	}
+	wildcards := []string{net.IPv4zero.String(), net.IPv6unspecified.String()}
+	if nf.family == syscall.AF_INET6 {
+		// ipv4 connection of dual stack listen socket is in tcp6
+		wildcards[0], wildcards[1] = wildcards[1], wildcards[0]
+	}
+	for _, ip := range wildcards {
+		if i, ok := wanted[ListenProcessKey(netns, nf.protocol, ip, local.Port)]; ok {
+			return i, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,112 @@
+package listen_process
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+/*
+ *  @Description: append lines to a socket table of the fake proc dir
+ */
+func appendFile(t *testing.T, path string, lines string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(lines); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCountConnections(t *testing.T) {
+	root := copyFixtureProc(t)
+	// besides the established connection 20003 of mysqld and its client 20004 in the fixture
+	appendFile(t, filepath.Join(root, "net", "tcp"), ""+
+		"   4: 0100007F:0CEA 0100007F:D432 06 00000000:00000000 03:00000F2A 00000000     0        0 0 3 0000000000000000\n"+
+		"   5: 0100007F:0CEA 0100007F:D433 08 00000000:00000000 00:00000000 00000000   999        0 20005 1 0000000000000000 20 4 30 10 -1\n"+
+		"   6: 0100000A:0016 0500000A:C350 01 00000000:00000000 02:0000A7C6 00000000     0        0 20006 2 0000000000000000 20 4 30 10 -1\n"+
+		// 10.0.0.1:3306 is not listened, mysqld is bound to 127.0.0.1
+		"   7: 0100000A:0CEA 0500000A:C351 01 00000000:00000000 00:00000000 00000000   999        0 20007 1 0000000000000000 20 4 30 10 -1\n")
+	appendFile(t, filepath.Join(root, "net", "tcp6"), ""+
+		// ipv4 client of dual stack [::]:8080
+		"   2: 0000000000000000FFFF00000100000A:1F90 0000000000000000FFFF00000500000A:C352 01 00000000:00000000 00:00000000 00000000    33        0 20013 1 0000000000000000 20 4 30 10 -1\n"+
+		"   3: 0000000000000000FFFF00000100000A:1F90 B80D0120000000000000000010000000:C353 03 00000000:00000000 00:00000000 00000000    33        0 0 1 0000000000000000\n"+
+		"   4: 00000000000000000000000001000000:0CEA 00000000000000000000000001000000:D434 01 00000000:00000000 00:00000000 00000000   999        0 20014 1 0000000000000000 20 4 30 10 -1\n")
+
+	mysqld := ListenProcess{IP: "127.0.0.1", Port: 3306, Protocol: ProtocolTCP, Netns: 4026531992}
+	sshd := ListenProcess{IP: "0.0.0.0", Port: 22, Protocol: ProtocolTCP, Netns: 4026531992}
+	web := ListenProcess{IP: "::", Port: 8080, Protocol: ProtocolTCP, Netns: 4026531992}
+	mysqld6 := ListenProcess{IP: "::1", Port: 3306, Protocol: ProtocolTCP, Netns: 4026531992}
+	dns := ListenProcess{IP: "0.0.0.0", Port: 53, Protocol: ProtocolUDP, Netns: 4026531992}
+	want := map[string]*Connections{
+		mysqld.Key(): {
+			States:  map[TcpState]int{TcpEstablished: 1, TcpTimeWait: 1, TcpCloseWait: 1},
+			Clients: map[Client]int{{Addr: "127.0.0.0/24", Type: ClientLoopback}: 3},
+		},
+		sshd.Key(): {
+			States:  map[TcpState]int{TcpEstablished: 1},
+			Clients: map[Client]int{{Addr: "10.0.0.0/24", Type: ClientExternal}: 1},
+		},
+		web.Key(): {
+			States: map[TcpState]int{TcpEstablished: 1, TcpSynRecv: 1},
+			Clients: map[Client]int{{Addr: "10.0.0.0/24", Type: ClientExternal}: 1,
+				{Addr: "2001:db8::/64", Type: ClientExternal}: 1},
+		},
+		mysqld6.Key(): {
+			States:  map[TcpState]int{TcpEstablished: 1},
+			Clients: map[Client]int{{Addr: "::/64", Type: ClientLoopback}: 1},
+		},
+	}
+	got := CountConnections(WithSocketTables(context.Background()), []ListenProcess{mysqld, sshd, web, mysqld6, dns},
+		&ClientAggregation{IPv4Prefix: 24, IPv6Prefix: 64})
+	if len(got) != len(want) {
+		t.Errorf("connections of %d listen sockets, want %d", len(got), len(want))
+	}
+	for k, c := range want {
+		if !reflect.DeepEqual(got[k], c) {
+			t.Errorf("connections of %s = %+v, want %+v", k, got[k], c)
+		}
+	}
+
+	// without client aggregation
+	got = CountConnections(WithSocketTables(context.Background()), []ListenProcess{mysqld}, nil)
+	if c := got[mysqld.Key()]; c.Clients != nil || !reflect.DeepEqual(c.States, want[mysqld.Key()].States) {
+		t.Errorf("connections of %s = %+v, want states %v only", mysqld.Key(), c, want[mysqld.Key()].States)
+	}
+}
+
+func TestClientAggregation(t *testing.T) {
+	tests := []struct {
+		ipv4, ipv6 int
+		ip         string
+		want       Client
+	}{
+		{ipv4: 32, ipv6: 128, ip: "10.1.2.3", want: Client{Addr: "10.1.2.3", Type: ClientExternal}},
+		{ipv4: 24, ipv6: 128, ip: "10.1.2.3", want: Client{Addr: "10.1.2.0/24", Type: ClientExternal}},
+		{ipv4: 16, ipv6: 128, ip: "10.1.2.3", want: Client{Addr: "10.1.0.0/16", Type: ClientExternal}},
+		{ipv4: 1, ipv6: 128, ip: "192.168.1.1", want: Client{Addr: "128.0.0.0/1", Type: ClientExternal}},
+		{ipv4: 20, ipv6: 128, ip: "172.16.31.200", want: Client{Addr: "172.16.16.0/20", Type: ClientExternal}},
+		{ipv4: 8, ipv6: 128, ip: "127.0.0.1", want: Client{Addr: "127.0.0.0/8", Type: ClientLoopback}},
+		// ipv4 client of dual stack socket is aggregated by ipv4 prefix
+		{ipv4: 24, ipv6: 64, ip: "::ffff:10.1.2.3", want: Client{Addr: "10.1.2.0/24", Type: ClientExternal}},
+		{ipv4: 32, ipv6: 64, ip: "::ffff:10.1.2.3", want: Client{Addr: "10.1.2.3", Type: ClientExternal}},
+		{ipv4: 24, ipv6: 128, ip: "2001:0db8:0001:0002:0000:0000:0000:0010", want: Client{Addr: "2001:db8:1:2::10", Type: ClientExternal}},
+		{ipv4: 24, ipv6: 128, ip: "2001:db8:1:2::10", want: Client{Addr: "2001:db8:1:2::10", Type: ClientExternal}},
+		{ipv4: 24, ipv6: 64, ip: "2001:db8:1:2::10", want: Client{Addr: "2001:db8:1:2::/64", Type: ClientExternal}},
+		{ipv4: 24, ipv6: 48, ip: "2001:db8:1:2::10", want: Client{Addr: "2001:db8:1::/48", Type: ClientExternal}},
+		{ipv4: 24, ipv6: 56, ip: "2001:db8:1:2ff::10", want: Client{Addr: "2001:db8:1:200::/56", Type: ClientExternal}},
+		{ipv4: 24, ipv6: 64, ip: "::1", want: Client{Addr: "::/64", Type: ClientLoopback}},
+		{ipv4: 24, ipv6: 64, ip: "unknown", want: Client{Addr: "unknown", Type: ClientExternal}},
+	}
+	for _, tt := range tests {
+		a := &ClientAggregation{IPv4Prefix: tt.ipv4, IPv6Prefix: tt.ipv6}
+		if got := a.client(tt.ip); got != tt.want {
+			t.Errorf("client(%s) with prefix /%d /%d = %+v, want %+v", tt.ip, tt.ipv4, tt.ipv6, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Package listen_process
+// @Description: backend to discover listen socket
+package listen_process
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+const (
+	DiscovererProcfs  = "procfs"
+	DiscovererNetlink = "netlink"
+)
+
+/*
+ *  @Description: discover listen sockets, the processes holding them are resolved by one shared
+ *                pass of /proc/<pid>/fd, see resolveListenSockets
+ */
+type ListenDiscoverer interface {
+	Name() string
+	ListenSockets(ctx context.Context) ([]ListenSocket, error)
+}
+
+var (
+	listenDiscoverer ListenDiscoverer = procfsDiscoverer{}
+)
+
+/*
+ * @Description: set backend to discover listen socket, netlink falls back to procfs when unavailable
+ * @Param name: procfs or netlink
+ * @Return error:
+ */
+func SetListenDiscoverer(name string) error {
+	switch name {
+	case DiscovererProcfs:
+		listenDiscoverer = procfsDiscoverer{}
+	case DiscovererNetlink:
+		if err := netlinkAvailable(); err != nil {
+			log.Printf("netlink sock_diag unavailable, fall back to procfs: %v", err)
+			listenDiscoverer = procfsDiscoverer{}
+			return nil
+		}
+		listenDiscoverer = netlinkDiscoverer{}
+	default:
+		return fmt.Errorf("unknown listen discoverer %s", name)
+	}
+	log.Printf("set listen discoverer: %s", listenDiscoverer.Name())
+	return nil
+}
+
+/*
+ *  @Description: socket tables of the network namespace of this exporter are dumped through sock_diag
+ */
+func useSockDiag() bool {
+	return listenDiscoverer.Name() == DiscovererNetlink
+}
+
+/*
+ * @Description: discover listen process with the selected backend
+ * @Param ctx:
+ * @Return map[string]ListenProcess:
+ * @Return error:
+ */
+func discoverListenProcess(ctx context.Context) (map[string]ListenProcess, error) {
+	sockets, err := listListenSockets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resolveListenSockets(ctx, sockets, sockets)
+}
+
+/*
+ * @Description: list listen sockets with the selected backend, fall back to procfs on error
+ * @Param ctx:
+ * @Return []ListenSocket:
+ * @Return error:
+ */
+func listListenSockets(ctx context.Context) ([]ListenSocket, error) {
+	sockets, err := listenDiscoverer.ListenSockets(ctx)
+	if err != nil && listenDiscoverer.Name() != DiscovererProcfs {
+		log.Printf("discover listen process through %s error, fall back to procfs: %v", listenDiscoverer.Name(), err)
+		sockets, err = procfsDiscoverer{}.ListenSockets(ctx)
+	}
+	return sockets, err
+}
+
+/*
+ * @Description: list listen sockets of one network namespace with the selected backend, fall back to procfs on error
+ * @Param ctx:
+ * @Param ns:
+ * @Return []ListenSocket:
+ * @Return error:
+ */
+func netnsListenSockets(ctx context.Context, ns netNamespace) ([]ListenSocket, error) {
+	if useSockDiag() && ns.inode == selfNetns() {
+		sockets, err := netlinkListenSockets(ctx, ns)
+		if err == nil {
+			return sockets, nil
+		}
+		log.Printf("discover listen process through %s error, fall back to procfs: %v", DiscovererNetlink, err)
+	}
+	return collectNetnsListenProcess(ctx, ns, netFiles)
+}
+
+/*
+ *  @Description: parse /proc/net/tcp /proc/net/udp and /proc/net/unix
+ */
+type procfsDiscoverer struct{}
+
+func (procfsDiscoverer) Name() string {
+	return DiscovererProcfs
+}
+
+func (procfsDiscoverer) ListenSockets(ctx context.Context) ([]ListenSocket, error) {
+	return collectListenProcess(ctx)
+}
@@ -0,0 +1,79 @@
+package listen_process
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+/*
+ * @Description: open listen sockets and established connections on loopback, closed at the end of the benchmark
+ * @Param b:
+ * @Param listeners: number of tcp listen sockets
+ * @Param conns: number of established connections, both ends are in the socket table
+ */
+func openSockets(b *testing.B, listeners int, conns int) {
+	b.Helper()
+	var lns []net.Listener
+	b.Cleanup(func() {
+		for _, ln := range lns {
+			ln.Close()
+		}
+	})
+	for i := 0; i < listeners; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			b.Skipf("listen: %v", err)
+		}
+		lns = append(lns, ln)
+	}
+	if len(lns) == 0 {
+		return
+	}
+	accepted := make(chan net.Conn, conns)
+	go func() {
+		for {
+			c, err := lns[0].Accept()
+			if err != nil {
+				return
+			}
+			accepted <- c
+		}
+	}()
+	for i := 0; i < conns; i++ {
+		c, err := net.Dial("tcp", lns[0].Addr().String())
+		if err != nil {
+			b.Skipf("dial: %v", err)
+		}
+		s := <-accepted
+		b.Cleanup(func() {
+			c.Close()
+			s.Close()
+		})
+	}
+}
+
+func benchmarkDiscoverer(b *testing.B, d ListenDiscoverer) {
+	openSockets(b, 512, 2048)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sockets, err := d.ListenSockets(context.Background())
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(sockets) < 512 {
+			b.Fatalf("found %d listen sockets, want at least 512", len(sockets))
+		}
+	}
+}
+
+func BenchmarkDiscovererProcfs(b *testing.B) {
+	benchmarkDiscoverer(b, procfsDiscoverer{})
+}
+
+func BenchmarkDiscovererNetlink(b *testing.B) {
+	if err := netlinkAvailable(); err != nil {
+		b.Skipf("netlink sock_diag unavailable: %v", err)
+	}
+	benchmarkDiscoverer(b, netlinkDiscoverer{})
+}
@@ -0,0 +1,77 @@
+package listen_process
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"listen_process_exporter/comm"
+)
+
+/*
+ *  @Description: process of a generated /proc tree
+ */
+type fakeProc struct {
+	pid     int32
+	ppid    int32
+	comm    string
+	sockets []string // inode of sockets held by the process
+	files   int      // number of fds which are not socket
+}
+
+/*
+ * @Description: generate /proc/<pid>/stat and /proc/<pid>/fd of processes, and use it as proc dir during the test
+ * @Param tb:
+ * @Param procs:
+ * @Return string: proc dir
+ */
+func writeFakeProc(tb testing.TB, procs ...fakeProc) string {
+	tb.Helper()
+	root := tb.TempDir()
+	for _, p := range procs {
+		dir := filepath.Join(root, strconv.Itoa(int(p.pid)))
+		if err := os.MkdirAll(filepath.Join(dir, "fd"), 0755); err != nil {
+			tb.Fatal(err)
+		}
+		name := p.comm
+		if name == "" {
+			name = "fake"
+		}
+		stat := fmt.Sprintf("%d (%s) S %d %d %d 0 -1 4194560 100 0 0 0 10 5 0 0 20 0 1 0 %d "+
+			"1000000 200 18446744073709551615 0 0 0 0 0 0 0 0 0 0 0 0 17 0 0 0 0 0 0 0 0 0 0 0 0 0 0\n",
+			p.pid, name, p.ppid, p.pid, p.pid, 1000+p.pid)
+		if err := os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644); err != nil {
+			tb.Fatal(err)
+		}
+		fd := 0
+		for ; fd < p.files; fd++ {
+			if err := os.Symlink("/dev/null", filepath.Join(dir, "fd", strconv.Itoa(fd))); err != nil {
+				tb.Fatal(err)
+			}
+		}
+		for _, inode := range p.sockets {
+			if err := os.Symlink("socket:["+inode+"]", filepath.Join(dir, "fd", strconv.Itoa(fd))); err != nil {
+				tb.Fatal(err)
+			}
+			fd++
+		}
+	}
+	useProcDir(tb, root)
+	return root
+}
+
+/*
+ *  @Description: set proc dir during the test
+ */
+func useProcDir(tb testing.TB, dir string) {
+	tb.Helper()
+	old := comm.ProcDir()
+	if err := comm.SetProcDir(dir); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		_ = comm.SetProcDir(old)
+	})
+}
@@ -0,0 +1,270 @@
+// Package listen_process
+// @Description: scan /proc/<pid>/fd with a bounded worker pool
+package listen_process
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"listen_process_exporter/comm"
+)
+
+const (
+	DefaultScanWorkers    = 4
+	DefaultScanPidTimeout = time.Second
+
+	SkipReasonTimeout    = "timeout"
+	SkipReasonHung       = "hung" // the scan given up before is still blocked in the kernel
+	SkipReasonPermission = "permission_denied"
+	SkipReasonError      = "read_failed"
+)
+
+var (
+	scanWorkers    = DefaultScanWorkers
+	scanPidTimeout = DefaultScanPidTimeout
+	scanAllHolders = false
+
+	scanStatsLock = sync.RWMutex{}
+	lastScanStats = ScanStats{Skipped: map[string]int{}}
+
+	// pids whose scan timed out and is still blocked in readlink, such as fd of a dead FUSE or NFS mount.
+	// The pid is skipped until the blocked scan returns, so at most one goroutine per pid is left behind
+	hungLock = sync.Mutex{}
+	hungPids = map[int32]struct{}{}
+)
+
+/*
+ *  @Description: stats of the last /proc/<pid>/fd scan
+ */
+type ScanStats struct {
+	Pids     int            `json:"pids"`
+	Scanned  int            `json:"scanned"` // less than pids when stopped early
+	Skipped  map[string]int `json:"skipped"` // skipped pids by reason
+	Hung     int            `json:"hung"`    // scans given up but still blocked in the kernel
+	Duration time.Duration  `json:"duration"`
+}
+
+/*
+ * @Description: set worker count and per pid timeout of /proc/<pid>/fd scan
+ * @Param workers: number of pids scanned in parallel
+ * @Param pidTimeout: give up a pid whose fd is slow to read, such as FUSE backed fd
+ * @Return error:
+ */
+func SetScanOption(workers int, pidTimeout time.Duration) error {
+	if workers < 1 {
+		return errors.New("invalid scan workers")
+	}
+	if pidTimeout <= 0 {
+		return errors.New("invalid scan pid timeout")
+	}
+	scanWorkers = workers
+	scanPidTimeout = pidTimeout
+	log.Printf("set scan workers: %d pid timeout: %v", workers, pidTimeout)
+	return nil
+}
+
+/*
+ * @Description: scan every pid to find all processes holding the listen socket, such as workers of
+ *               pre-fork server and SO_REUSEPORT sockets, otherwise stop once every socket has a holder
+ * @Param enable:
+ */
+func SetScanAllHolders(enable bool) {
+	scanAllHolders = enable
+	log.Printf("set scan all holders = %v", enable)
+}
+
+/*
+ *  @Description: stats of the last /proc/<pid>/fd scan
+ */
+func LastScanStats() ScanStats {
+	scanStatsLock.RLock()
+	defer scanStatsLock.RUnlock()
+	return lastScanStats
+}
+
+/*
+ * @Description: find processes holding wanted socket inodes with a pool of scanWorkers.
+ *   Pids are dispatched in ascending order and dispatching stops once every wanted inode has a holder.
+ *   Every pid dispatched is scanned, so the holder with the lowest pid is always found, such as
+ *   the master of pre-fork server. With scanAllHolders every pid is scanned, a socket may be held by processes
+ *   which are not descendants of each other, such as passed through SCM_RIGHTS, and the pid of a child
+ *   may be lower than its parent after pid wrap.
+ * @Param ctx: stop scanning when done
+ * @Param root: proc dir
+ * @Param wanted: socket inodes
+ * @Return map[string][]inodeMap: processes by inode
+ * @Return error:
+ */
+func getProcInodesWanted(ctx context.Context, root string, wanted map[string]struct{}) (map[string][]inodeMap, error) {
+	start := time.Now()
+	pids, err := PidsWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pids, func(i, j int) bool {
+		return pids[i] < pids[j]
+	})
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		ret      = make(map[string][]inodeMap, len(wanted))
+		stats    = ScanStats{Pids: len(pids), Skipped: map[string]int{}}
+		pidCh    = make(chan int32)
+		missing  = len(wanted) // wanted inodes without holder, guarded by mu
+		found    = make(chan struct{})
+		stopOnce sync.Once
+	)
+	if len(wanted) == 0 {
+		return ret, nil
+	}
+
+	worker := func() {
+		defer wg.Done()
+		for pid := range pidCh {
+			t, err := getProcInodesTimeout(ctx, root, pid)
+			mu.Lock()
+			stats.Scanned++
+			if err != nil {
+				if reason := skipReason(ctx, err); reason != "" {
+					stats.Skipped[reason]++
+					if comm.Debug() {
+						log.Printf("skip scan pid %d fd: %v", pid, err)
+					}
+				}
+				mu.Unlock()
+				continue
+			}
+			for inode, m := range t {
+				if _, ok := wanted[inode]; !ok || len(m) == 0 {
+					continue
+				}
+				if len(ret[inode]) == 0 {
+					missing--
+				}
+				ret[inode] = append(ret[inode], m...)
+			}
+			if missing == 0 && !scanAllHolders {
+				stopOnce.Do(func() {
+					close(found)
+				})
+			}
+			mu.Unlock()
+		}
+	}
+	for i := 0; i < scanWorkers; i++ {
+		wg.Add(1)
+		go worker()
+	}
+dispatch:
+	for _, pid := range pids {
+		select {
+		case pidCh <- pid:
+		case <-found:
+			break dispatch
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(pidCh)
+	wg.Wait()
+
+	stats.Duration = time.Since(start)
+	hungLock.Lock()
+	stats.Hung = len(hungPids)
+	hungLock.Unlock()
+	scanStatsLock.Lock()
+	lastScanStats = stats
+	scanStatsLock.Unlock()
+
+	return ret, ctx.Err()
+}
+
+// read socket inodes of pid, replaced by test to block like readlink of a dead mount
+var procInodes = getProcInodes
+
+var (
+	errScanPidTimeout = errors.New("scan pid fd timeout")
+	errScanPidHung    = errors.New("scan pid fd still blocked")
+)
+
+/*
+ * @Description: read socket inodes of pid, give up after scanPidTimeout.
+ *   readlink can not be cancelled, the abandoned goroutine exits when the syscall returns,
+ *   until then the pid is not scanned again
+ * @Param ctx:
+ * @Param root: proc dir
+ * @Param pid:
+ * @Return map[string][]inodeMap:
+ * @Return error:
+ */
+func getProcInodesTimeout(ctx context.Context, root string, pid int32) (map[string][]inodeMap, error) {
+	type result struct {
+		inodes map[string][]inodeMap
+		err    error
+	}
+	hungLock.Lock()
+	_, hung := hungPids[pid]
+	hungLock.Unlock()
+	if hung {
+		return nil, errScanPidHung
+	}
+	ch := make(chan result, 1)
+	abandoned := false // guarded by hungLock
+	go func() {
+		t, err := procInodes(root, pid, 0)
+		hungLock.Lock()
+		defer hungLock.Unlock()
+		ch <- result{inodes: t, err: err}
+		if abandoned {
+			delete(hungPids, pid)
+		}
+	}()
+	timer := time.NewTimer(scanPidTimeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case r := <-ch:
+		return r.inodes, r.err
+	case <-timer.C:
+		err = errScanPidTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	hungLock.Lock()
+	defer hungLock.Unlock()
+	select {
+	case r := <-ch:
+		// returned while giving up
+		return r.inodes, r.err
+	default:
+	}
+	abandoned = true
+	hungPids[pid] = struct{}{}
+	return nil, err
+}
+
+/*
+ * @Description: reason of skipping pid
+ * @Param ctx:
+ * @Param err: error of reading /proc/<pid>/fd
+ * @Return string: empty when the process exited or the scan is cancelled
+ */
+func skipReason(ctx context.Context, err error) string {
+	switch {
+	case os.IsNotExist(err) || err == io.EOF || ctx.Err() != nil:
+		return ""
+	case errors.Is(err, errScanPidTimeout):
+		return SkipReasonTimeout
+	case errors.Is(err, errScanPidHung):
+		return SkipReasonHung
+	case os.IsPermission(err):
+		return SkipReasonPermission
+	}
+	return SkipReasonError
+}
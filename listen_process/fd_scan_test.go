@@ -0,0 +1,283 @@
+package listen_process
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func holderPids(holders []inodeMap) []int32 {
+	var pids []int32
+	for _, p := range socketProcesses(holders) {
+		pids = append(pids, p.Pid)
+	}
+	return pids
+}
+
+/*
+ *  @Description: set scan workers during the test
+ */
+func useScanWorkers(tb testing.TB, workers int) {
+	tb.Helper()
+	old := scanWorkers
+	if err := SetScanOption(workers, scanPidTimeout); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		scanWorkers = old
+	})
+}
+
+/*
+ *  @Description: scan every pid during the test
+ */
+func useScanAllHolders(tb testing.TB) {
+	tb.Helper()
+	scanAllHolders = true
+	tb.Cleanup(func() {
+		scanAllHolders = false
+	})
+}
+
+func TestGetProcInodesWantedEarlyStop(t *testing.T) {
+	procs := []fakeProc{
+		{pid: 1, ppid: 0, files: 3},
+		{pid: 100, ppid: 1, sockets: []string{"1001"}},
+		{pid: 101, ppid: 1, sockets: []string{"2002"}},
+		// SO_REUSEPORT socket of another process
+		{pid: 300, ppid: 1, sockets: []string{"1001"}},
+	}
+	for i := int32(0); i < 64; i++ {
+		procs = append(procs, fakeProc{pid: 200 + i, ppid: 1, files: 4})
+	}
+	root := writeFakeProc(t, procs...)
+	wanted := map[string]struct{}{"1001": {}, "2002": {}}
+	for _, workers := range []int{1, 4} {
+		useScanWorkers(t, workers)
+		for i := 0; i < 20; i++ {
+			inodes, err := getProcInodesWanted(context.Background(), root, wanted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := holderPids(inodes["1001"]); !reflect.DeepEqual(got, []int32{100}) {
+				t.Fatalf("%d workers holders of 1001 = %v, want [100]", workers, got)
+			}
+			if got := holderPids(inodes["2002"]); !reflect.DeepEqual(got, []int32{101}) {
+				t.Fatalf("%d workers holders of 2002 = %v, want [101]", workers, got)
+			}
+			// pids dispatched to the workers before the stop are scanned too
+			limit := len(procs) - 1
+			if workers == 1 {
+				limit = 4
+			}
+			if stats := LastScanStats(); stats.Scanned > limit || stats.Pids != len(procs) {
+				t.Fatalf("%d workers scanned %d of %d pids, want stop after pid 101", workers, stats.Scanned, stats.Pids)
+			}
+		}
+	}
+
+	useScanAllHolders(t)
+	inodes, err := getProcInodesWanted(context.Background(), root, wanted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := holderPids(inodes["1001"]); !reflect.DeepEqual(got, []int32{100, 300}) {
+		t.Errorf("all holders of 1001 = %v, want [100 300]", got)
+	}
+	if stats := LastScanStats(); stats.Scanned != len(procs) {
+		t.Errorf("scanned %d of %d pids with all holders", stats.Scanned, len(procs))
+	}
+}
+
+func TestGetProcInodesWantedUnrelatedHolders(t *testing.T) {
+	useScanWorkers(t, 1)
+	useScanAllHolders(t)
+	procs := []fakeProc{
+		{pid: 1, ppid: 0, files: 3},
+		// child with lower pid than its parent after pid wrap
+		{pid: 50, ppid: 300, sockets: []string{"1001"}},
+		{pid: 300, ppid: 1, sockets: []string{"1001"}},
+		{pid: 200, ppid: 1, sockets: []string{"2002"}},
+	}
+	want := []int32{50, 300}
+	for i := int32(0); i < 32; i++ {
+		p := fakeProc{pid: 1000 + i, ppid: 1, files: 3}
+		if i%4 == 0 {
+			// received the socket through SCM_RIGHTS, not a descendant of the holders above
+			p.sockets = []string{"1001"}
+			want = append(want, p.pid)
+		}
+		procs = append(procs, p)
+	}
+	root := writeFakeProc(t, procs...)
+	wanted := map[string]struct{}{"1001": {}, "2002": {}}
+	inodes, err := getProcInodesWanted(context.Background(), root, wanted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := holderPids(inodes["1001"]); !reflect.DeepEqual(got, want) {
+		t.Errorf("holders of 1001 = %v, want %v", got, want)
+	}
+	if got, want := holderPids(inodes["2002"]), []int32{200}; !reflect.DeepEqual(got, want) {
+		t.Errorf("holders of 2002 = %v, want %v", got, want)
+	}
+}
+
+func TestGetProcInodesWantedPreforkWorkers(t *testing.T) {
+	useScanWorkers(t, 4)
+	useScanAllHolders(t)
+	// pre-fork server, master and workers share the listen socket, and workers hold connections of their own
+	procs := []fakeProc{{pid: 1, ppid: 0, files: 3}}
+	want := []int32{100}
+	procs = append(procs, fakeProc{pid: 100, ppid: 1, sockets: []string{"1001"}, files: 8})
+	for i := int32(1); i <= 16; i++ {
+		procs = append(procs, fakeProc{pid: 100 + i, ppid: 100, sockets: []string{"1001", strconv.Itoa(int(5000 + i))}})
+		want = append(want, 100+i)
+	}
+	for i := int32(0); i < 64; i++ {
+		procs = append(procs, fakeProc{pid: 200 + i, ppid: 1, files: 4})
+	}
+	root := writeFakeProc(t, procs...)
+	wanted := map[string]struct{}{"1001": {}}
+	for i := 0; i < 50; i++ {
+		inodes, err := getProcInodesWanted(context.Background(), root, wanted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := holderPids(inodes["1001"]); !reflect.DeepEqual(got, want) {
+			t.Fatalf("round %d holders of 1001 = %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestGetProcInodesWantedCancelled(t *testing.T) {
+	root := writeFakeProc(t, fakeProc{pid: 100, ppid: 1, sockets: []string{"1001"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := getProcInodesWanted(ctx, root, map[string]struct{}{"1001": {}}); err != context.Canceled {
+		t.Errorf("error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestGetProcInodesWantedHungPid(t *testing.T) {
+	useScanWorkers(t, 2)
+	useScanAllHolders(t)
+	old := scanPidTimeout
+	scanPidTimeout = 20 * time.Millisecond
+	defer func() {
+		scanPidTimeout = old
+	}()
+	root := writeFakeProc(t,
+		fakeProc{pid: 100, ppid: 1, sockets: []string{"1001"}},
+		fakeProc{pid: 101, ppid: 1, files: 3},
+		fakeProc{pid: 102, ppid: 1, files: 3},
+	)
+	// readlink of pid 101 blocks like fd of a dead FUSE mount until released
+	var (
+		mu      sync.Mutex
+		blocked int
+		release = make(chan struct{})
+	)
+	procInodes = func(root string, pid int32, max int) (map[string][]inodeMap, error) {
+		if pid == 101 {
+			mu.Lock()
+			blocked++
+			mu.Unlock()
+			<-release
+		}
+		return getProcInodes(root, pid, max)
+	}
+	defer func() {
+		procInodes = getProcInodes
+	}()
+
+	wanted := map[string]struct{}{"1001": {}}
+	for i := 0; i < 5; i++ {
+		inodes, err := getProcInodesWanted(context.Background(), root, wanted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := holderPids(inodes["1001"]); !reflect.DeepEqual(got, []int32{100}) {
+			t.Fatalf("round %d holders of 1001 = %v, want [100]", i, got)
+		}
+		stats := LastScanStats()
+		if stats.Hung != 1 {
+			t.Errorf("round %d hung scans = %d, want 1", i, stats.Hung)
+		}
+		reason := SkipReasonHung
+		if i == 0 {
+			reason = SkipReasonTimeout
+		}
+		if stats.Skipped[reason] != 1 {
+			t.Errorf("round %d skipped = %v, want 1 %s", i, stats.Skipped, reason)
+		}
+	}
+	mu.Lock()
+	if blocked != 1 {
+		t.Errorf("%d scans of pid 101 are blocked, want 1", blocked)
+	}
+	mu.Unlock()
+
+	// scanned again once the blocked scan returns
+	close(release)
+	for i := 0; ; i++ {
+		hungLock.Lock()
+		n := len(hungPids)
+		hungLock.Unlock()
+		if n == 0 {
+			break
+		}
+		if i > 100 {
+			t.Fatal("blocked scan of pid 101 is not released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := getProcInodesWanted(context.Background(), root, wanted); err != nil {
+		t.Fatal(err)
+	}
+	if stats := LastScanStats(); stats.Hung != 0 || len(stats.Skipped) != 0 {
+		t.Errorf("stats after release = %+v, want nothing skipped", stats)
+	}
+}
+
+func BenchmarkGetProcInodesWanted(b *testing.B) {
+	// 12k fds in 200 processes, the listen socket is held by a master and its 8 workers
+	var procs []fakeProc
+	for i := 0; i < 200; i++ {
+		procs = append(procs, fakeProc{pid: int32(100 + i), ppid: 1, files: 60})
+	}
+	procs[10].sockets = []string{"9001"}
+	for i := 0; i < 8; i++ {
+		procs[11+i].ppid = procs[10].pid
+		procs[11+i].sockets = []string{"9001"}
+	}
+	root := writeFakeProc(b, procs...)
+	for _, bm := range []struct {
+		name    string
+		all     bool
+		holders int
+	}{
+		{name: "first_holder", holders: 1},
+		{name: "all_holders", all: true, holders: 9},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			scanAllHolders = bm.all
+			defer func() {
+				scanAllHolders = false
+			}()
+			wanted := map[string]struct{}{"9001": {}}
+			for i := 0; i < b.N; i++ {
+				inodes, err := getProcInodesWanted(context.Background(), root, wanted)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if n := len(socketProcesses(inodes["9001"])); n < bm.holders {
+					b.Fatalf("found %d holders, want %d", n, bm.holders)
+				}
+			}
+		})
+	}
+}
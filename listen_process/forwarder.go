@@ -0,0 +1,246 @@
+// Package listen_process
+// @Description: userland port forwarder such as docker-proxy
+package listen_process
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"listen_process_exporter/comm"
+)
+
+/*
+ *  @Description: parse the backend address from the arguments of forwarder
+ */
+type forwarderParser func(args []string) (Target, bool)
+
+/*
+ *  @Description: known forwarders by exe name
+ */
+var forwarders = map[string]forwarderParser{
+	"docker-proxy": parseDockerProxyArgs,
+}
+
+/*
+ * @Description: parse -proto -container-ip -container-port of docker-proxy
+ * @Param args: cmdline without exe
+ * @Return Target: listen address in the container
+ * @Return bool: all required arguments are found
+ */
+func parseDockerProxyArgs(args []string) (Target, bool) {
+	flags := make(map[string]string)
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimLeft(args[i], "-")
+		if name == args[i] {
+			continue
+		}
+		if j := strings.Index(name, "="); j >= 0 {
+			flags[name[:j]] = name[j+1:]
+			continue
+		}
+		if i+1 < len(args) {
+			flags[name] = args[i+1]
+			i++
+		}
+	}
+	t := Target{Protocol: ProtocolTCP}
+	if proto, ok := flags["proto"]; ok {
+		t.Protocol = strings.ToLower(proto)
+	}
+	if t.Protocol != ProtocolTCP && t.Protocol != ProtocolUDP {
+		return t, false
+	}
+	ip := net.ParseIP(flags["container-ip"])
+	port, err := strconv.ParseUint(flags["container-port"], 10, 16)
+	if ip == nil || err != nil {
+		return t, false
+	}
+	t.IP, t.Port = ip.String(), uint32(port)
+	return t, true
+}
+
+/*
+ * @Description: backend address of forwarder process
+ * @Param pid: master process of the listen socket
+ * @Return Target: listen address of backend
+ * @Return bool: the process is a known forwarder
+ */
+func forwarderTarget(pid int32) (Target, bool) {
+	// argv[0] is set by the process itself, only trust the exe, which is unreadable without CAP_SYS_PTRACE
+	exe, err := os.Readlink(fmt.Sprintf("%s/%d/exe", comm.ProcDir(), pid))
+	if err != nil {
+		return Target{}, false
+	}
+	parse, ok := forwarders[filepath.Base(strings.TrimSuffix(exe, " (deleted)"))]
+	if !ok {
+		return Target{}, false
+	}
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("%s/%d/cmdline", comm.ProcDir(), pid))
+	if err != nil || len(cmdline) == 0 {
+		return Target{}, false
+	}
+	args := strings.Split(string(bytes.TrimRight(cmdline, "\x00")), "\x00")
+	return parse(args[1:])
+}
+
+/*
+ *  @Description: one of listen processes is a known forwarder
+ */
+func hasForwarder(processList map[string]ListenProcess) bool {
+	for _, lp := range processList {
+		if lp.Pid == 0 || lp.ForwarderPid != 0 {
+			continue
+		}
+		if _, ok := forwarderTarget(lp.Pid); ok {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ * @Description: replace processes of forwarder with the process listening on the backend address,
+ *               which is in another network namespace such as the container
+ * @Param ctx:
+ * @Param processList: listen process found, the backend is added if not found yet
+ * @Param sockets: listen sockets of every network namespace
+ */
+func resolveForwarders(ctx context.Context, processList map[string]ListenProcess, sockets []ListenSocket) {
+	// the backend may be added to processList
+	keys := make([]string, 0, len(processList))
+	for k := range processList {
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		lp := processList[k]
+		if lp.Pid == 0 || lp.ForwarderPid != 0 {
+			continue
+		}
+		target, ok := forwarderTarget(lp.Pid)
+		if !ok {
+			continue
+		}
+		backend, ok := forwarderBackend(ctx, processList, sockets, lp, target)
+		if !ok {
+			if comm.Debug() {
+				log.Printf("not found backend %s of forwarder %d listen %s", target, lp.Pid, k)
+			}
+			continue
+		}
+		lp.ForwarderPid = lp.Pid
+		lp.Pid = backend.Pid
+		lp.Processes = backend.Processes
+		lp.backend = backend.Key()
+		processList[k] = lp
+	}
+}
+
+/*
+ * @Description: find the listen process of backend address in other network namespaces
+ * @Param ctx:
+ * @Param processList: listen process found, the backend is added if not found yet
+ * @Param sockets: listen sockets of every network namespace
+ * @Param forwarder: listen process of forwarder
+ * @Param target: backend address
+ * @Return ListenProcess: backend
+ * @Return bool: found only one backend
+ */
+func forwarderBackend(ctx context.Context, processList map[string]ListenProcess, sockets []ListenSocket,
+	forwarder ListenProcess, target Target) (ListenProcess, bool) {
+	// candidate sockets by network namespace, bound to the backend ip or any address
+	candidates := make(map[uint64][]ListenSocket)
+	for _, s := range sockets {
+		if s.Netns == forwarder.Netns || !target.matchPort(s.ListenProcess) {
+			continue
+		}
+		if exact, wildcard := target.matchIP(s.ListenProcess); exact || wildcard {
+			candidates[s.Netns] = append(candidates[s.Netns], s)
+		}
+	}
+	if len(candidates) > 1 {
+		// several containers listen the same port, keep the one owning the backend ip
+		for netns, s := range candidates {
+			if !netnsHasIP(s[0].netDir(), target.IP) {
+				delete(candidates, netns)
+			}
+		}
+	}
+	if len(candidates) != 1 {
+		return ListenProcess{}, false
+	}
+	var unresolved []ListenSocket
+	for _, c := range candidates {
+		for _, s := range c {
+			if _, ok := processList[s.Key()]; !ok {
+				unresolved = append(unresolved, s)
+			}
+		}
+		if len(unresolved) > 0 {
+			resolved, err := resolveSocketProcesses(ctx, unresolved)
+			if err != nil {
+				return ListenProcess{}, false
+			}
+			for k, v := range resolved {
+				processList[k] = v
+			}
+		}
+		// prefer the socket bound to the backend ip
+		var backend ListenProcess
+		for _, s := range c {
+			lp := processList[s.Key()]
+			if exact, _ := target.matchIP(lp); exact || backend.Protocol == "" {
+				backend = lp
+			}
+		}
+		return backend, backend.Pid != 0
+	}
+	return ListenProcess{}, false
+}
+
+/*
+ * @Description: whether the ip is a local address of network namespace
+ * @Param netDir: socket tables of the network namespace
+ * @Param ip:
+ * @Return bool:
+ */
+func netnsHasIP(netDir string, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	if parsed.To4() == nil {
+		// address column of if_inet6 is 32 hex digits without colon
+		contents, err := ioutil.ReadFile(filepath.Join(netDir, "if_inet6"))
+		if err != nil {
+			return false
+		}
+		hex := fmt.Sprintf("%x", []byte(parsed.To16()))
+		for _, line := range strings.Split(string(contents), "\n") {
+			if f := strings.Fields(line); len(f) > 0 && f[0] == hex {
+				return true
+			}
+		}
+		return false
+	}
+	// local address in fib_trie is followed by "/32 host LOCAL"
+	contents, err := ioutil.ReadFile(filepath.Join(netDir, "fib_trie"))
+	if err != nil {
+		return false
+	}
+	lines := strings.Split(string(contents), "\n")
+	for i := 0; i+1 < len(lines); i++ {
+		f := strings.Fields(lines[i])
+		if len(f) == 2 && f[0] == "|--" && f[1] == parsed.String() && strings.Contains(lines[i+1], "host LOCAL") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package listen_process
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseDockerProxyArgs(t *testing.T) {
+	tests := []struct {
+		args string
+		want Target
+		ok   bool
+	}{
+		{args: "-proto tcp -host-ip 0.0.0.0 -host-port 8080 -container-ip 172.17.0.2 -container-port 80",
+			want: Target{Protocol: ProtocolTCP, IP: "172.17.0.2", Port: 80}, ok: true},
+		{args: "-proto udp -host-ip :: -host-port 53 -container-ip fd00::2 -container-port 53",
+			want: Target{Protocol: ProtocolUDP, IP: "fd00::2", Port: 53}, ok: true},
+		// host address is not the backend
+		{args: "-host-ip 127.0.0.1 -host-port 3306 -container-ip 172.17.0.3 -container-port 3306",
+			want: Target{Protocol: ProtocolTCP, IP: "172.17.0.3", Port: 3306}, ok: true},
+		{args: "--proto=TCP --container-ip=172.17.0.2 --container-port=443",
+			want: Target{Protocol: ProtocolTCP, IP: "172.17.0.2", Port: 443}, ok: true},
+		{args: "-proto sctp -container-ip 172.17.0.2 -container-port 80"},
+		{args: "-proto tcp -host-ip 0.0.0.0 -host-port 8080 -container-port 80"},
+		{args: "-proto tcp -host-ip 0.0.0.0 -host-port 8080 -container-ip 172.17.0.2"},
+		{args: "-container-ip 172.17.0.2 -container-port"},
+		{args: "-container-ip container -container-port 80"},
+		{args: "-container-ip 172.17.0.2 -container-port 65536"},
+		{args: ""},
+	}
+	for _, tt := range tests {
+		got, ok := parseDockerProxyArgs(strings.Fields(tt.args))
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("parseDockerProxyArgs(%s) = %+v, %v, want %+v, %v", tt.args, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestForwarderTarget(t *testing.T) {
+	root := writeFakeProc(t,
+		fakeProc{pid: 100, ppid: 1, comm: "docker-proxy"},
+		fakeProc{pid: 101, ppid: 1, comm: "docker-proxy"},
+		fakeProc{pid: 102, ppid: 1, comm: "docker-proxy"},
+		fakeProc{pid: 103, ppid: 1, comm: "docker-proxy"},
+	)
+	args := "-proto tcp -host-ip 0.0.0.0 -host-port 8080 -container-ip 172.17.0.2 -container-port 80"
+	backend := Target{Protocol: ProtocolTCP, IP: "172.17.0.2", Port: 80}
+	tests := []struct {
+		pid  int32
+		exe  string // empty means unreadable
+		argv string
+		want bool
+	}{
+		{pid: 100, exe: "/usr/bin/docker-proxy", argv: "/usr/bin/docker-proxy " + args, want: true},
+		// upgraded while running
+		{pid: 101, exe: "/usr/bin/docker-proxy (deleted)", argv: "docker-proxy " + args, want: true},
+		// any process may name itself docker-proxy
+		{pid: 102, exe: "/usr/bin/python3", argv: "/usr/bin/docker-proxy " + args},
+		{pid: 103, argv: "/usr/bin/docker-proxy " + args},
+	}
+	for _, tt := range tests {
+		dir := filepath.Join(root, strconv.Itoa(int(tt.pid)))
+		if tt.exe != "" {
+			if err := os.Symlink(tt.exe, filepath.Join(dir, "exe")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cmdline := strings.Join(strings.Fields(tt.argv), "\x00") + "\x00"
+		if err := os.WriteFile(filepath.Join(dir, "cmdline"), []byte(cmdline), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, ok := forwarderTarget(tt.pid)
+		if ok != tt.want || (ok && got != backend) {
+			t.Errorf("forwarderTarget(%d) exe %q = %+v, %v, want forwarder %v", tt.pid, tt.exe, got, ok, tt.want)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"context"
 	"errors"
 	"log"
+	"sort"
 	"sync"
 	"time"
 
@@ -20,7 +21,7 @@ const (
 var (
 	refreshIntervalSecond = 60
 	t                     = time.NewTicker(DefaultInterval)
-	listenProcessCache    = map[uint32]ListenProcess{}
+	listenProcessCache    = map[string]ListenProcess{}
 	lock                  = sync.RWMutex{}
 	refreshTime           = time.Unix(0, 0)
 )
@@ -43,16 +44,179 @@ func SetTickerInterval(intervalSecond int) error {
 	return nil
 }
 
-func GetListenPortPid(listenPort uint32) (ListenProcess, error) {
-	if v, exist := getListenProcessPid(listenPort); exist {
-		return v, nil
+/*
+ *  @Description: get all listen process matching target, bare port may match several bind address
+ */
+func GetListenPortPid(ctx context.Context, target Target) ([]ListenProcess, error) {
+	lps, errs := GetListenPortPids(ctx, []Target{target})
+	return lps[0], errs[0]
+}
+
+/*
+ * @Description: get listen process of every target, targets missing in the cache or stale are resolved together
+ * @Param ctx: stop resolving when the caller gives up, such as the probe request is cancelled
+ * @Param targets:
+ * @Return [][]ListenProcess: listen process in the order of targets, nil when not found
+ * @Return []error: error of every target without listen process, ErrNotFound when the target is not listening,
+ *                  otherwise the error to discover listen socket or resolve its processes
+ */
+func GetListenPortPids(ctx context.Context, targets []Target) ([][]ListenProcess, []error) {
+	ret := make([][]ListenProcess, len(targets))
+	errs := make([]error, len(targets))
+	defer func() {
+		for i := range targets {
+			if len(ret[i]) == 0 && errs[i] == nil {
+				errs[i] = ErrNotFound
+			}
+		}
+	}()
+	var cached []ListenProcess
+	for i, target := range targets {
+		ret[i] = getListenProcessPid(target)
+		cached = append(cached, ret[i]...)
 	}
-	// if not exist then refresh
-	_, _ = RefreshListenProcess(context.Background())
-	if v, exist := listenProcessCache[listenPort]; exist {
-		return v, nil
+	stale := staleListenProcess(ctx, cached)
+	var missing []int
+	for i := range targets {
+		if len(ret[i]) == 0 {
+			missing = append(missing, i)
+			continue
+		}
+		for _, lp := range ret[i] {
+			if stale[lp.Key()] {
+				missing = append(missing, i)
+				break
+			}
+		}
+	}
+	if len(missing) == 0 {
+		return ret, errs
+	}
+	resolve := make([]Target, 0, len(missing))
+	for _, i := range missing {
+		resolve = append(resolve, targets[i])
+	}
+	if err := resolveTarget(ctx, resolve...); err != nil {
+		log.Printf("resolve listen %d targets error: %v", len(resolve), err)
+		// wrong process is worse than nothing
+		for _, i := range missing {
+			var valid []ListenProcess
+			for _, lp := range ret[i] {
+				if !stale[lp.Key()] {
+					valid = append(valid, lp)
+				}
+			}
+			ret[i] = valid
+			if len(valid) == 0 {
+				errs[i] = err
+			}
+		}
+		return ret, errs
+	}
+	for _, i := range missing {
+		ret[i] = getListenProcessPid(targets[i])
+	}
+	return ret, errs
+}
+
+/*
+ * @Description: find listen sockets of target ports in socket tables, then search /proc/<pid>/fd
+ *               for their inodes only and merge the result into the cache
+ * @Param ctx:
+ * @Param targets:
+ * @Return error:
+ */
+func resolveTarget(ctx context.Context, targets ...Target) error {
+	sockets, walked, err := targetListenSockets(ctx, targets)
+	if err != nil {
+		return err
+	}
+	var wanted []ListenSocket
+	for _, s := range sockets {
+		for _, target := range targets {
+			if target.matchPort(s.ListenProcess) {
+				wanted = append(wanted, s)
+				break
+			}
+		}
+	}
+	processList := map[string]ListenProcess{}
+	if len(wanted) > 0 {
+		if processList, err = resolveSocketProcesses(ctx, wanted); err != nil {
+			return err
+		}
+	}
+	all := sockets
+	if hasForwarder(processList) {
+		// the backend may be in any network namespace
+		if all, err = listListenSockets(ctx); err != nil {
+			return err
+		}
+		walked = nil
+	}
+	resolveForwarders(ctx, processList, all)
+	mergeListenProcessCache(targets, walked, processList)
+	return nil
+}
+
+/*
+ * @Description: list listen sockets of the network namespaces holding targets, the namespace of this exporter
+ *               first. The walk stops once every target is found, other namespaces are not read
+ * @Param ctx:
+ * @Param targets:
+ * @Return []ListenSocket: listen sockets of the namespaces read
+ * @Return map[uint64]bool: inode of the namespaces read
+ * @Return error:
+ */
+func targetListenSockets(ctx context.Context, targets []Target) ([]ListenSocket, map[uint64]bool, error) {
+	var (
+		sockets []ListenSocket
+		walked  = make(map[uint64]bool)
+		pending = targets
+		err     error
+	)
+	walkErr := walkNetNamespaces(ctx, func(ns netNamespace) bool {
+		inNetns := false
+		for _, t := range pending {
+			if t.Netns == 0 || t.Netns == ns.inode {
+				inNetns = true
+				break
+			}
+		}
+		if !inNetns {
+			return true
+		}
+		s, e := netnsListenSockets(ctx, ns)
+		if e != nil {
+			if ns.inode == selfNetns() {
+				err = e
+				return false
+			}
+			// process of the namespace exited
+			return true
+		}
+		walked[ns.inode] = true
+		sockets = append(sockets, s...)
+		var rest []Target
+		for _, t := range pending {
+			found := false
+			for _, socket := range s {
+				if t.matchPort(socket.ListenProcess) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				rest = append(rest, t)
+			}
+		}
+		pending = rest
+		return len(pending) > 0
+	})
+	if err == nil {
+		err = walkErr
 	}
-	return ListenProcess{}, errors.New("listen_process not found")
+	return sockets, walked, err
 }
 
 /*
@@ -74,14 +238,14 @@ func RefreshListenProcessGoroutine() {
 	}
 }
 
-func RefreshListenProcess(ctx context.Context) (listenProcess map[uint32]ListenProcess, err error) {
+func RefreshListenProcess(ctx context.Context) (listenProcess map[string]ListenProcess, err error) {
 	if comm.Debug() {
 		log.Printf("check refresh listen process")
 	}
 	if time.Now().Sub(refreshTime).Seconds() < float64(refreshIntervalSecond) {
 		return
 	}
-	if listenProcess, err = collectListenProcess(ctx); err == nil {
+	if listenProcess, err = discoverListenProcess(ctx); err == nil {
 		resetListenProcessCache(listenProcess)
 	}
 	if comm.Debug() {
@@ -90,23 +254,98 @@ func RefreshListenProcess(ctx context.Context) (listenProcess map[uint32]ListenP
 	return
 }
 
-func resetListenProcessCache(cache map[uint32]ListenProcess) {
+func resetListenProcessCache(cache map[string]ListenProcess) {
 	lock.Lock()
 	defer lock.Unlock()
 	listenProcessCache = cache
 	if comm.Debug() {
 		for k, v := range cache {
-			log.Printf("found listen port %d pid %d  ", k, v.Pid)
+			log.Printf("found listen %s pid %d  ", k, v.Pid)
+		}
+	}
+}
+
+/*
+ * @Description: replace listen process of the resolved targets in cache, keep the others
+ * @Param targets:
+ * @Param walked: network namespaces resolved, nil means every namespace
+ * @Param processList: listen process resolved
+ */
+func mergeListenProcessCache(targets []Target, walked map[uint64]bool, processList map[string]ListenProcess) {
+	lock.Lock()
+	defer lock.Unlock()
+	// copy on write, the replaced cache may still be used by the caller of RefreshListenProcess
+	cache := make(map[string]ListenProcess, len(listenProcessCache)+len(processList))
+	for k, v := range listenProcessCache {
+		if !matchAnyTarget(targets, v) || (walked != nil && !walked[v.Netns]) {
+			cache[k] = v
+		}
+	}
+	for k, v := range processList {
+		cache[k] = v
+		if comm.Debug() {
+			log.Printf("found listen %s pid %d  ", k, v.Pid)
 		}
 	}
+	listenProcessCache = cache
 }
 
 /*
- *  @Description: get listen process pid
+ *  @Description: listen process is bound to the port of one of targets, not yet resolved again
  */
-func getListenProcessPid(listenPort uint32) (p ListenProcess, exist bool) {
+func matchAnyTarget(targets []Target, lp ListenProcess) bool {
+	for _, target := range targets {
+		if target.matchPort(lp) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ *  @Description: get listen process pid, listen process bound to the exact ip of target is
+ *                preferred to the one bound to any address in each network namespace.
+ *                Backend reached through a forwarder is only reported with the forwarder
+ */
+func getListenProcessPid(target Target) (lps []ListenProcess) {
 	lock.RLock()
 	defer lock.RUnlock()
-	p, exist = listenProcessCache[listenPort]
+	exact := make(map[uint64]bool)
+	var wildcard []ListenProcess
+	for _, p := range listenProcessCache {
+		if !target.matchPort(p) {
+			continue
+		}
+		e, w := target.matchIP(p)
+		if e {
+			exact[p.Netns] = true
+			lps = append(lps, p)
+		} else if w {
+			wildcard = append(wildcard, p)
+		}
+	}
+	for _, p := range wildcard {
+		if !exact[p.Netns] {
+			lps = append(lps, p)
+		}
+	}
+	backends := make(map[string]struct{})
+	for _, p := range lps {
+		if p.backend != "" {
+			backends[p.backend] = struct{}{}
+		}
+	}
+	if len(backends) > 0 {
+		filtered := lps[:0]
+		for _, p := range lps {
+			if _, ok := backends[p.Key()]; !ok {
+				filtered = append(filtered, p)
+			}
+		}
+		lps = filtered
+	}
+	sort.Slice(lps, func(i, j int) bool {
+		return lps[i].Key() < lps[j].Key()
+	})
 	return
 }
@@ -0,0 +1,168 @@
+package listen_process
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+/*
+ *  @Description: replace the listen process cache during the test
+ */
+func useListenProcessCache(t *testing.T, lps ...ListenProcess) {
+	t.Helper()
+	lock.Lock()
+	old := listenProcessCache
+	cache := make(map[string]ListenProcess, len(lps))
+	for _, lp := range lps {
+		cache[lp.Key()] = lp
+	}
+	listenProcessCache = cache
+	lock.Unlock()
+	t.Cleanup(func() {
+		lock.Lock()
+		listenProcessCache = old
+		lock.Unlock()
+	})
+}
+
+func TestGetListenProcessPidForwarderBackend(t *testing.T) {
+	const host, container = 4026531992, 4026532500
+	backend := ListenProcess{Pid: 300, Processes: []SocketProcess{{Pid: 300}}, IP: "0.0.0.0", Port: 8080,
+		Protocol: ProtocolTCP, Netns: container}
+	forwarder := ListenProcess{Pid: 300, Processes: []SocketProcess{{Pid: 300}}, IP: "0.0.0.0", Port: 8080,
+		Protocol: ProtocolTCP, Netns: host, ForwarderPid: 200, backend: backend.Key()}
+	other := ListenProcess{Pid: 400, Processes: []SocketProcess{{Pid: 400}}, IP: "0.0.0.0", Port: 8080,
+		Protocol: ProtocolTCP, Netns: 4026532600}
+	useListenProcessCache(t, backend, forwarder, other)
+
+	var keys []string
+	for _, lp := range getListenProcessPid(Target{Protocol: ProtocolTCP, Port: 8080}) {
+		keys = append(keys, lp.Key())
+	}
+	if want := []string{forwarder.Key(), other.Key()}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("listen process of bare port = %v, want %v", keys, want)
+	}
+
+	// the backend is still reported when its network namespace is probed
+	lps := getListenProcessPid(Target{Protocol: ProtocolTCP, Port: 8080, Netns: container})
+	if len(lps) != 1 || lps[0].Key() != backend.Key() {
+		t.Errorf("listen process of container = %v, want %s", lps, backend.Key())
+	}
+}
+
+/*
+ * @Description: write socket tables of a fake network namespace with tcp listen sockets
+ * @Param t:
+ * @Param netDir: such as <proc>/net or <proc>/<pid>/net
+ * @Param listeners: port by socket inode
+ */
+func writeFakeNetns(t *testing.T, netDir string, listeners map[string]uint32) {
+	t.Helper()
+	if err := os.MkdirAll(netDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	tcp := "  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+	i := 0
+	for inode, port := range listeners {
+		tcp += fmt.Sprintf("%4d: 00000000:%04X 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 %s 1 0000000000000000 100 0 0 10 0\n",
+			i, port, inode)
+		i++
+	}
+	tables := map[string]string{
+		"tcp":  tcp,
+		"tcp6": "  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode\n",
+		"udp":  "   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops\n",
+		"udp6": "  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops\n",
+		"unix": "Num       RefCount Protocol Flags    Type St Inode Path\n",
+	}
+	for name, content := range tables {
+		if err := os.WriteFile(filepath.Join(netDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+/*
+ *  @Description: link /proc/<pid>/ns/net, or /proc/self/ns/net, to the network namespace
+ */
+func linkFakeNetns(t *testing.T, pidDir string, inode uint64) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(pidDir, "ns"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(fmt.Sprintf("net:[%d]", inode), filepath.Join(pidDir, "ns", "net")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/*
+ *  @Description: read network namespace of this exporter again from the proc dir of the test
+ */
+func useSelfNetns(t *testing.T) {
+	t.Helper()
+	selfNetnsOnce = sync.Once{}
+	t.Cleanup(func() {
+		selfNetnsOnce = sync.Once{}
+	})
+}
+
+func TestTargetListenSockets(t *testing.T) {
+	const self, web, api = 1000, 2000, 3000
+	root := writeFakeProc(t,
+		fakeProc{pid: 100, ppid: 1, sockets: []string{"5001"}},
+		fakeProc{pid: 200, ppid: 1, sockets: []string{"6001", "6002"}},
+		fakeProc{pid: 300, ppid: 1, sockets: []string{"7001"}},
+	)
+	useSelfNetns(t)
+	linkFakeNetns(t, filepath.Join(root, "self"), self)
+	writeFakeNetns(t, filepath.Join(root, "net"), map[string]uint32{"5001": 3306})
+	linkFakeNetns(t, filepath.Join(root, "100"), self)
+	linkFakeNetns(t, filepath.Join(root, "200"), web)
+	writeFakeNetns(t, filepath.Join(root, "200", "net"), map[string]uint32{"6001": 3306, "6002": 8080})
+	linkFakeNetns(t, filepath.Join(root, "300"), api)
+	writeFakeNetns(t, filepath.Join(root, "300", "net"), map[string]uint32{"7001": 9090})
+
+	tests := []struct {
+		target Target
+		walked map[uint64]bool
+	}{
+		// found in the namespace of the exporter, containers are not read
+		{target: Target{Protocol: ProtocolTCP, Port: 3306}, walked: map[uint64]bool{self: true}},
+		// stop at the first namespace holding the socket
+		{target: Target{Protocol: ProtocolTCP, Port: 8080}, walked: map[uint64]bool{self: true, web: true}},
+		// only the namespace of target is read
+		{target: Target{Protocol: ProtocolTCP, Port: 9090, Netns: api}, walked: map[uint64]bool{api: true}},
+		// not found anywhere
+		{target: Target{Protocol: ProtocolTCP, Port: 1234}, walked: map[uint64]bool{self: true, web: true, api: true}},
+	}
+	for _, tt := range tests {
+		_, walked, err := targetListenSockets(context.Background(), []Target{tt.target})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(walked, tt.walked) {
+			t.Errorf("namespaces read for %s = %v, want %v", tt.target, walked, tt.walked)
+		}
+	}
+
+	// container listening the same port is kept in the cache when the target is found in the exporter namespace
+	container := ListenProcess{Pid: 200, Processes: []SocketProcess{{Pid: 200}}, IP: "0.0.0.0", Port: 3306,
+		Protocol: ProtocolTCP, Netns: web, Inodes: []string{"6001"}}
+	useListenProcessCache(t, container)
+	target := Target{Protocol: ProtocolTCP, Port: 3306}
+	if err := resolveTarget(context.Background(), target); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, lp := range getListenProcessPid(target) {
+		got = append(got, fmt.Sprintf("%s pid %d", lp.Key(), lp.Pid))
+	}
+	if want := []string{"1000/tcp/0.0.0.0:3306 pid 100", "2000/tcp/0.0.0.0:3306 pid 200"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("listen process of %s = %v, want %v", target, got, want)
+	}
+}
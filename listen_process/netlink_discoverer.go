@@ -0,0 +1,151 @@
+// Package listen_process
+// @Description: discover listen socket through NETLINK_SOCK_DIAG
+package listen_process
+
+import (
+	"context"
+	"encoding/binary"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+const (
+	// INET_DIAG_SKMEMINFO of include/uapi/linux/inet_diag.h and SK_MEMINFO_DROPS of include/uapi/linux/sock_diag.h
+	inetDiagSkMemInfo = 7
+	skMemInfoDrops    = 8
+)
+
+/*
+ *  @Description: ask the kernel only for listen sockets instead of reading every socket in /proc/net/tcp,
+ *                unix socket is still read from /proc/net/unix. sock_diag only sees the network namespace
+ *                of this exporter, other namespaces are read from /proc/<pid>/net
+ */
+type netlinkDiscoverer struct{}
+
+func (netlinkDiscoverer) Name() string {
+	return DiscovererNetlink
+}
+
+func (netlinkDiscoverer) ListenSockets(ctx context.Context) ([]ListenSocket, error) {
+	namespaces, err := listNetNamespaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sockets, err := netlinkListenSockets(ctx, namespaces[0])
+	if err != nil {
+		return sockets, err
+	}
+	for _, ns := range namespaces[1:] {
+		s, err := collectNetnsListenProcess(ctx, ns, netFiles)
+		if err != nil {
+			// process of the namespace exited
+			continue
+		}
+		sockets = append(sockets, s...)
+	}
+	return sockets, nil
+}
+
+/*
+ *  @Description: listen sockets of the network namespace of this exporter
+ */
+func netlinkListenSockets(ctx context.Context, self netNamespace) ([]ListenSocket, error) {
+	var sockets []ListenSocket
+	for _, nf := range netFiles {
+		lines, err := diagSocketLines(nf, true)
+		if err != nil {
+			return sockets, err
+		}
+		for _, sl := range lines {
+			lp := ListenProcess{
+				IP:       sl.local.IP,
+				Port:     sl.local.Port,
+				Protocol: nf.protocol,
+				State:    sl.state,
+			}
+			sl.setQueue(&lp)
+			sockets = append(sockets, ListenSocket{ListenProcess: lp, Inode: sl.inode})
+		}
+	}
+	s, err := getListenUnixService(ctx, filepath.Join(self.netDir, "unix"))
+	if err != nil {
+		return sockets, err
+	}
+	sockets = append(sockets, s...)
+	for i := range sockets {
+		sockets[i].Netns = self.inode
+		sockets[i].nsDir = self.netDir
+	}
+	return sockets, nil
+}
+
+/*
+ * @Description: dump listen or not listen sockets of the network namespace of this exporter through sock_diag,
+ *               the kernel only returns sockets of the wanted states
+ * @Param nf: protocol and family
+ * @Param listen: listening tcp socket and unconnected udp socket, otherwise connections
+ * @Return []socketLine:
+ * @Return error:
+ */
+func diagSocketLines(nf netFile, listen bool) ([]socketLine, error) {
+	var (
+		proto       uint8 = syscall.IPPROTO_TCP
+		listenState       = TcpListen
+		ext         uint8
+	)
+	if nf.protocol == ProtocolUDP {
+		// unconnected udp socket, drops are only reported in sk_meminfo
+		proto, listenState, ext = syscall.IPPROTO_UDP, TcpClose, 1<<(inetDiagSkMemInfo-1)
+	}
+	states := tcpStateMask(listenState)
+	if !listen {
+		states = tcpStateMask(append(ConnectionStates, TcpListen)...) &^ states
+	}
+	entries, err := sockDiagDump(uint8(nf.family), proto, states, ext, 0)
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]socketLine, 0, len(entries))
+	for _, entry := range entries {
+		lines = append(lines, diagSocketLine(nf, entry))
+	}
+	return lines, nil
+}
+
+/*
+ * @Description: socket returned by sock_diag as if it was read from /proc/net/tcp or /proc/net/udp
+ * @Param nf: protocol and family
+ * @Param entry:
+ * @Return socketLine:
+ */
+func diagSocketLine(nf netFile, entry sockDiagEntry) socketLine {
+	sl := socketLine{
+		local:      entry.local(),
+		remoteAddr: entry.remote(),
+		state:      TcpState(entry.msg.State),
+		rxQueue:    uint64(entry.msg.RQueue),
+		inode:      strconv.FormatUint(uint64(entry.msg.Inode), 10),
+	}
+	if nf.protocol == ProtocolUDP {
+		if mem := entry.attrs[inetDiagSkMemInfo]; len(mem) >= (skMemInfoDrops+1)*4 {
+			sl.drops = uint64(binary.NativeEndian.Uint32(mem[skMemInfoDrops*4:]))
+		}
+	} else if sl.state == TcpListen {
+		// for listen socket idiag_rqueue is the accept queue and idiag_wqueue is the backlog
+		sl.backlog = uint64(entry.msg.WQueue)
+	}
+	return sl
+}
+
+/*
+ *  @Description: check NETLINK_SOCK_DIAG works, such as denied by seccomp or missing udp_diag
+ */
+func netlinkAvailable() error {
+	for _, proto := range []uint8{syscall.IPPROTO_TCP, syscall.IPPROTO_UDP} {
+		if _, err := sockDiagDump(syscall.AF_INET, proto, tcpStateMask(TcpListen), 0, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
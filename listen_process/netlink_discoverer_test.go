@@ -0,0 +1,89 @@
+package listen_process
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// inet_diag_msg of unconnected udp socket 127.0.0.1:44832 with INET_DIAG_SKMEMINFO, captured on x86_64
+// after flooding the socket with 200 datagrams: rmem_alloc 2304 rcvbuf 2304 ... drops 199
+const udpSkMemInfoMsg = "02070000af2000007f00000100000000000000000000000000000000000000000000000000000000000000006b08" +
+	"0000000000000000000000090000000000000000000040fd0100050008000000000008000f00000000000c0015000100" +
+	"0000000000000600160050000000280007000009000000090000000000000040030000070000000000000000000000" +
+	"000000c7000000"
+
+/*
+ *  @Description: select listen discoverer during the test
+ */
+func useListenDiscoverer(tb testing.TB, d ListenDiscoverer) {
+	tb.Helper()
+	old := listenDiscoverer
+	listenDiscoverer = d
+	tb.Cleanup(func() {
+		listenDiscoverer = old
+	})
+}
+
+func TestDiagSocketLineSkMemInfo(t *testing.T) {
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("message is captured on little endian host")
+	}
+	data, err := hex.DecodeString(udpSkMemInfoMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := parseSockDiagMsg(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mem := entry.attrs[inetDiagSkMemInfo]; len(mem) != 9*4 {
+		t.Fatalf("sk_meminfo = %x, want 9 counters", mem)
+	}
+	sl := diagSocketLine(netFile{protocol: ProtocolUDP, family: syscall.AF_INET}, entry)
+	if sl.local != (Addr{IP: "127.0.0.1", Port: 44832}) || sl.state != TcpClose || sl.inode != "130368" {
+		t.Errorf("socket = %+v", sl)
+	}
+	if sl.rxQueue != 2304 || sl.drops != 199 {
+		t.Errorf("rx queue %d drops %d, want 2304 and 199", sl.rxQueue, sl.drops)
+	}
+}
+
+func TestSockDiagSocketTables(t *testing.T) {
+	if err := netlinkAvailable(); err != nil {
+		t.Skipf("netlink sock_diag unavailable: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("listen: %v", err)
+	}
+	defer ln.Close()
+	// not accepted, waiting in the accept queue
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// without socket tables in procfs only sock_diag finds the listener and its connection
+	netns := selfNetns()
+	useProcDir(t, t.TempDir())
+	useListenDiscoverer(t, netlinkDiscoverer{})
+	lp := ListenProcess{IP: "127.0.0.1", Port: uint32(ln.Addr().(*net.TCPAddr).Port), Protocol: ProtocolTCP, Netns: netns}
+	ctx := WithSocketTables(context.Background())
+
+	lps := RefreshSocketQueue(ctx, []ListenProcess{lp})
+	if lps[0].AcceptQueue != 1 || lps[0].Backlog == 0 {
+		t.Errorf("accept queue %d backlog %d, want 1 and the backlog of listen", lps[0].AcceptQueue, lps[0].Backlog)
+	}
+	connections := CountConnections(ctx, []ListenProcess{lp}, &ClientAggregation{IPv4Prefix: 32, IPv6Prefix: 128})
+	if n := connections[lp.Key()].States[TcpEstablished]; n != 1 {
+		t.Errorf("established connections = %d, want 1", n)
+	}
+	if n := connections[lp.Key()].Clients[Client{Addr: "127.0.0.1", Type: ClientLoopback}]; n != 1 {
+		t.Errorf("loopback client connections = %d, want 1", n)
+	}
+}
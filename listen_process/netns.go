@@ -0,0 +1,181 @@
+// Package listen_process
+// @Description: network namespace of listen socket
+package listen_process
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"listen_process_exporter/comm"
+)
+
+var (
+	selfNetnsOnce  sync.Once
+	selfNetnsInode uint64
+)
+
+/*
+ *  @Description: network namespace and a process in it, whose /proc/<pid>/net shows the socket tables
+ */
+type netNamespace struct {
+	inode  uint64
+	netDir string
+}
+
+/*
+ *  @Description: inode of network namespace of this exporter, /proc/net shows its socket tables
+ */
+func selfNetns() uint64 {
+	selfNetnsOnce.Do(func() {
+		inode, err := readNetns(comm.ProcDir() + "/self/ns/net")
+		if err != nil {
+			inode = 0
+		}
+		selfNetnsInode = inode
+	})
+	return selfNetnsInode
+}
+
+/*
+ *  @Description: socket tables of the network namespace of this exporter
+ */
+func selfNetDir() string {
+	return comm.ProcDir() + "/net"
+}
+
+/*
+ * @Description: read inode of network namespace from link such as net:[4026531992]
+ * @Param path: /proc/<pid>/ns/net
+ * @Return uint64: inode
+ * @Return error:
+ */
+func readNetns(path string) (uint64, error) {
+	link, err := os.Readlink(path)
+	if err != nil {
+		return 0, err
+	}
+	if !strings.HasPrefix(link, "net:[") || !strings.HasSuffix(link, "]") {
+		return 0, fmt.Errorf("invalid netns link %s", link)
+	}
+	return strconv.ParseUint(link[5:len(link)-1], 10, 64)
+}
+
+/*
+ * @Description: list distinct network namespaces by the inode of /proc/<pid>/ns/net,
+ *   the namespace of this exporter comes first and is read from /proc/net
+ * @Param ctx:
+ * @Return []netNamespace:
+ * @Return error:
+ */
+func listNetNamespaces(ctx context.Context) ([]netNamespace, error) {
+	var namespaces []netNamespace
+	err := walkNetNamespaces(ctx, func(ns netNamespace) bool {
+		namespaces = append(namespaces, ns)
+		return true
+	})
+	return namespaces, err
+}
+
+/*
+ * @Description: call fn with distinct network namespaces found through /proc/<pid>/ns/net until it returns false,
+ *   the namespace of this exporter comes first and is read from /proc/net
+ * @Param ctx:
+ * @Param fn: return false to stop the walk
+ * @Return error:
+ */
+func walkNetNamespaces(ctx context.Context, fn func(ns netNamespace) bool) error {
+	self := selfNetns()
+	if !fn(netNamespace{inode: self, netDir: selfNetDir()}) {
+		return nil
+	}
+	pids, err := PidsWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	// in order of pid, services started at boot come first
+	sort.Slice(pids, func(i, j int) bool {
+		return pids[i] < pids[j]
+	})
+	seen := map[uint64]struct{}{self: {}}
+	for _, pid := range pids {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		// permission denied without CAP_SYS_PTRACE, or process exited
+		inode, err := readNetns(fmt.Sprintf("%s/%d/ns/net", comm.ProcDir(), pid))
+		if err != nil {
+			continue
+		}
+		if _, ok := seen[inode]; ok {
+			continue
+		}
+		seen[inode] = struct{}{}
+		if !fn(netNamespace{inode: inode, netDir: fmt.Sprintf("%s/%d/net", comm.ProcDir(), pid)}) {
+			return nil
+		}
+	}
+	return nil
+}
+
+/*
+ * @Description: parse netns selector of probe
+ * @Param selector: empty for any namespace, host for the namespace of pid 1,
+ *                  pid:<pid> for the namespace of a process, or inode of the namespace
+ * @Return uint64: inode, 0 means any
+ * @Return error:
+ */
+func ParseNetns(selector string) (uint64, error) {
+	switch {
+	case selector == "":
+		return 0, nil
+	case selector == "host":
+		return readNetns(comm.ProcDir() + "/1/ns/net")
+	case strings.HasPrefix(selector, "pid:"):
+		pid, err := strconv.ParseInt(strings.TrimPrefix(selector, "pid:"), 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("netns pid[%s] must be number", selector)
+		}
+		return readNetns(fmt.Sprintf("%s/%d/ns/net", comm.ProcDir(), pid))
+	}
+	inode, err := strconv.ParseUint(selector, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("netns[%s] must be host, pid:<pid> or inode", selector)
+	}
+	return inode, nil
+}
+
+/*
+ *  @Description: socket tables of the namespace of listen process
+ */
+func (lp ListenProcess) netDir() string {
+	if lp.Netns == selfNetns() {
+		return selfNetDir()
+	}
+	// backend of forwarder is in another namespace
+	if lp.ForwarderPid != 0 {
+		return fmt.Sprintf("%s/%d/net", comm.ProcDir(), lp.ForwarderPid)
+	}
+	if lp.Pid != 0 {
+		return fmt.Sprintf("%s/%d/net", comm.ProcDir(), lp.Pid)
+	}
+	// the process the namespace was found through
+	return lp.nsDir
+}
+
+/*
+ *  @Description: socket tables of every network namespace the listen processes are in
+ */
+func netDirs(lps []ListenProcess) map[uint64]string {
+	dirs := make(map[uint64]string)
+	for _, lp := range lps {
+		if _, ok := dirs[lp.Netns]; !ok {
+			dirs[lp.Netns] = lp.netDir()
+		}
+	}
+	return dirs
+}
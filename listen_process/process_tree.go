@@ -0,0 +1,69 @@
+// Package listen_process
+// @Description: process tree built from ppid of /proc/<pid>/stat
+package listen_process
+
+import (
+	"context"
+	"sort"
+)
+
+/*
+ *  @Description: children by parent pid
+ */
+type ProcessTree map[int32][]int32
+
+/*
+ * @Description: build process tree from ppid of every process
+ * @Param ctx:
+ * @Return ProcessTree:
+ * @Return error:
+ */
+func LoadProcessTree(ctx context.Context) (ProcessTree, error) {
+	pids, err := PidsWithContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tree := make(ProcessTree)
+	for _, pid := range pids {
+		if err = ctx.Err(); err != nil {
+			return tree, err
+		}
+		ppid, err := readProcessPpid(pid)
+		if err != nil {
+			// process exited
+			continue
+		}
+		tree[ppid] = append(tree[ppid], pid)
+	}
+	return tree, nil
+}
+
+/*
+ * @Description: all descendants of processes, not including the processes themselves
+ * @Param pids: root processes
+ * @Return []int32: sorted by pid
+ */
+func (t ProcessTree) Descendants(pids []int32) []int32 {
+	seen := make(map[int32]struct{}, len(pids))
+	for _, pid := range pids {
+		seen[pid] = struct{}{}
+	}
+	var ret []int32
+	queue := append([]int32(nil), pids...)
+	for len(queue) > 0 {
+		pid := queue[0]
+		queue = queue[1:]
+		for _, child := range t[pid] {
+			if _, ok := seen[child]; ok {
+				continue
+			}
+			seen[child] = struct{}{}
+			ret = append(ret, child)
+			queue = append(queue, child)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i] < ret[j]
+	})
+	return ret
+}
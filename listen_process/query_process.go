@@ -6,26 +6,105 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"io"
 	"io/ioutil"
+	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
+
+	"listen_process_exporter/comm"
+)
+
+const (
+	ProtocolTCP  = "tcp"
+	ProtocolUDP  = "udp"
+	ProtocolUnix = "unix"
 )
 
 const (
-	LinuxProcDir         = "/proc"
-	LinuxProcNetTcpFile  = "/proc/net/tcp"
-	LinuxProcNetTcp6File = "/proc/net/tcp6"
+	// __SO_ACCEPTCON in /proc/net/unix flags, set on listening socket
+	unixFlagAcceptCon = 0x10000
+	unixTypeDgram     = 0x0002
+	unixStUnconnected = 0x01
 )
 
 /*
  *  @Description: struct
  */
 type ListenProcess struct {
-	Pid  int32  `json:"pid"`
-	Port uint32 `json:"port"`
+	Pid          int32           `json:"pid"`          // master process of the listen socket
+	Processes    []SocketProcess `json:"processes"`    // all processes holding the listen socket
+	IP           string          `json:"ip,omitempty"` // bind ip, tcp and udp only
+	Port         uint32          `json:"port"`
+	Protocol     string          `json:"protocol"`
+	State        TcpState        `json:"state,omitempty"`         // udp socket reuses the tcp state, unconnected is CLOSE
+	Path         string          `json:"path,omitempty"`          // unix only: socket path, abstract name starts with @
+	AcceptQueue  uint64          `json:"accept_queue"`            // tcp only: connections waiting to be accepted
+	Backlog      uint64          `json:"backlog"`                 // tcp only: max length of accept queue
+	RxQueue      uint64          `json:"rx_queue"`                // udp only: bytes waiting in the receive queue
+	Drops        uint64          `json:"drops"`                   // udp only: datagrams dropped by this socket
+	Netns        uint64          `json:"netns"`                   // inode of network namespace
+	ForwarderPid int32           `json:"forwarder_pid,omitempty"` // forwarder such as docker-proxy, Pid and Processes are its backend
+	Inodes       []string        `json:"inodes,omitempty"`        // inode of listen sockets, several with SO_REUSEPORT
+
+	nsDir   string // socket tables of the namespace, such as /proc/<pid>/net
+	backend string // forwarder only: key of the backend listen process in its own network namespace
+}
+
+/*
+ *  @Description: socket table in /proc/net, or /proc/<pid>/net of other network namespace
+ */
+type netFile struct {
+	protocol string
+	family   uint32
+	name     string
+}
+
+var netFiles = []netFile{
+	{protocol: ProtocolTCP, family: uint32(syscall.AF_INET), name: "tcp"},
+	{protocol: ProtocolTCP, family: uint32(syscall.AF_INET6), name: "tcp6"},
+	{protocol: ProtocolUDP, family: uint32(syscall.AF_INET), name: "udp"},
+	{protocol: ProtocolUDP, family: uint32(syscall.AF_INET6), name: "udp6"},
+}
+
+/*
+ *  @Description: cache key of listen process, such as 4026531992/tcp/127.0.0.1:3306 or 4026531992/udp/[::]:53
+ */
+func ListenProcessKey(netns uint64, protocol string, ip string, port uint32) string {
+	return strconv.FormatUint(netns, 10) + "/" + protocol + "/" + net.JoinHostPort(ip, strconv.FormatUint(uint64(port), 10))
+}
+
+/*
+ *  @Description: cache key of unix listen process, such as 4026531992/unix:/var/run/mysqld/mysqld.sock
+ */
+func UnixListenProcessKey(netns uint64, path string) string {
+	return strconv.FormatUint(netns, 10) + "/" + ProtocolUnix + ":" + path
+}
+
+/*
+ *  @Description: cache key of listen process
+ */
+func (lp ListenProcess) Key() string {
+	if lp.Protocol == ProtocolUnix {
+		return UnixListenProcessKey(lp.Netns, lp.Path)
+	}
+	return ListenProcessKey(lp.Netns, lp.Protocol, lp.IP, lp.Port)
+}
+
+/*
+ *  @Description: listen address, such as 127.0.0.1:3306 or /var/run/mysqld/mysqld.sock,
+ *                empty for process found without socket
+ */
+func (lp ListenProcess) Addr() string {
+	switch lp.Protocol {
+	case ProtocolUnix:
+		return lp.Path
+	case "":
+		return ""
+	}
+	return net.JoinHostPort(lp.IP, strconv.FormatUint(uint64(lp.Port), 10))
 }
 
 // PS:github.com/shirou/gopsutil
@@ -35,35 +114,65 @@ type inodeMap struct {
 }
 
 /*
- *  @Description: collect listen port
+ *  @Description: listen socket found in socket table, not resolved to process yet
+ */
+type ListenSocket struct {
+	ListenProcess
+	Inode string `json:"inode"`
+}
+
+/*
+ *  @Description: collect listen port of every network namespace
  */
-func collectListenProcess(ctx context.Context) (processList map[uint32]ListenProcess, err error) {
-	var lpArr map[uint32]ListenProcess
-	processList = make(map[uint32]ListenProcess)
-	// ipv4
-	lpArr, err = getListenIPVxService(ctx, uint32(syscall.AF_INET), LinuxProcNetTcpFile, true)
+func collectListenProcess(ctx context.Context) ([]ListenSocket, error) {
+	namespaces, err := listNetNamespaces(ctx)
 	if err != nil {
-		return
+		return nil, err
+	}
+	var sockets []ListenSocket
+	for i, ns := range namespaces {
+		s, err := collectNetnsListenProcess(ctx, ns, netFiles)
+		if err != nil {
+			if i == 0 {
+				return sockets, err
+			}
+			// process of the namespace exited
+			continue
+		}
+		sockets = append(sockets, s...)
 	}
-	for k, v := range lpArr {
-		processList[k] = v
+	return sockets, nil
+}
+
+/*
+ *  @Description: collect listen port of one network namespace
+ */
+func collectNetnsListenProcess(ctx context.Context, ns netNamespace, files []netFile) ([]ListenSocket, error) {
+	var sockets []ListenSocket
+	for _, nf := range files {
+		s, err := getListenIPVxService(ctx, nf.protocol, nf.family, filepath.Join(ns.netDir, nf.name), true)
+		if err != nil {
+			return sockets, err
+		}
+		sockets = append(sockets, s...)
 	}
-	// ipv6
-	lpArr6, err := getListenIPVxService(ctx, uint32(syscall.AF_INET6), LinuxProcNetTcp6File, true)
+	s, err := getListenUnixService(ctx, filepath.Join(ns.netDir, "unix"))
 	if err != nil {
-		return lpArr, err
+		return sockets, err
 	}
-	for k, v := range lpArr6 {
-		processList[k] = v
+	sockets = append(sockets, s...)
+	for i := range sockets {
+		sockets[i].Netns = ns.inode
+		sockets[i].nsDir = ns.netDir
 	}
-	return processList, err
+	return sockets, nil
 }
 
 /*
  *  @Description: read file and find listen port
  */
-func getListenIPVxService(ctx context.Context, family uint32, file string, listen bool) (map[uint32]ListenProcess, error) {
-	var lpArr = map[uint32]ListenProcess{}
+func getListenIPVxService(ctx context.Context, protocol string, family uint32, file string, listen bool) ([]ListenSocket, error) {
+	var sockets []ListenSocket
 
 	// Read the contents of the /proc file with a single read sys call.
 	// This minimizes duplicates in the returned connections
@@ -71,76 +180,120 @@ func getListenIPVxService(ctx context.Context, family uint32, file string, liste
 	// https://github.com/shirou/gopsutil/pull/361
 	contents, err := ioutil.ReadFile(file)
 	if err != nil {
-		return lpArr, err
+		return sockets, err
+	}
+	lines := bytes.Split(contents, []byte("\n"))
+	// skip first line
+	for _, line := range lines[1:] {
+		sl, err := parseSocketLine(protocol, family, strings.Fields(string(line)))
+		if err != nil {
+			continue
+		}
+		if listen && !sl.isListen(protocol) {
+			// 是连接不是监听端口
+			continue
+		}
+		lp := ListenProcess{
+			IP:       sl.local.IP,
+			Port:     sl.local.Port,
+			Protocol: protocol,
+			State:    sl.state,
+		}
+		sl.setQueue(&lp)
+		sockets = append(sockets, ListenSocket{ListenProcess: lp, Inode: sl.inode})
 	}
-	inodes, err := getProcInodesAll(ctx, LinuxProcDir, 0)
+
+	return sockets, nil
+
+}
+
+/*
+ *  @Description: read /proc/net/unix and find listen socket path
+ */
+func getListenUnixService(ctx context.Context, file string) ([]ListenSocket, error) {
+	var sockets []ListenSocket
+
+	contents, err := ioutil.ReadFile(file)
 	if err != nil {
-		return lpArr, err
+		return sockets, err
 	}
 	lines := bytes.Split(contents, []byte("\n"))
 	// skip first line
+	// Num RefCount Protocol Flags Type St Inode Path
 	for _, line := range lines[1:] {
-		var la, ra Addr
 		l := strings.Fields(string(line))
-		if len(l) < 10 {
+		if len(l) < 8 {
+			// unbound socket has no path
 			continue
 		}
-		lAddr := l[1]
-		rAddr := l[2]
-		pid := int32(0)
-		inode := l[9]
-		if la, err = decodeAddress(family, lAddr); err != nil {
+		flags, err := strconv.ParseUint(l[3], 16, 32)
+		if err != nil {
 			continue
 		}
-
-		if ra, err = decodeAddress(family, rAddr); err != nil {
+		typ, err := strconv.ParseUint(l[4], 16, 32)
+		if err != nil {
 			continue
 		}
-		i, exists := inodes[inode]
-		if exists {
-			pid = i[0].pid
+		st, err := strconv.ParseUint(l[5], 16, 32)
+		if err != nil {
+			continue
 		}
-
-		if listen {
-			// 0.0.0.0 or :: or ::1
-			if ra.IP != "0.0.0.0" && strings.Trim(ra.IP, ":") != "" && strings.Trim(ra.IP, ":") != "1" {
-				// 是连接不是监听端口
-				continue
-			}
+		if flags&unixFlagAcceptCon == 0 && !(typ == unixTypeDgram && st == unixStUnconnected) {
+			// not listening stream socket nor bound datagram socket
+			continue
 		}
-		lpArr[la.Port] = ListenProcess{
-			Pid:  pid,
-			Port: la.Port,
+		lp := ListenProcess{
+			Protocol: ProtocolUnix,
+			Path:     strings.Join(l[7:], " "),
 		}
+		sockets = append(sockets, ListenSocket{ListenProcess: lp, Inode: l[6]})
 	}
-
-	return lpArr, nil
-
+	return sockets, nil
 }
 
-func getProcInodesAll(ctx context.Context, root string, max int) (map[string][]inodeMap, error) {
-	pids, err := PidsWithContext(ctx)
+/*
+ * @Description: resolve listen sockets to processes, forwarder is followed to its backend
+ * @Param ctx:
+ * @Param sockets: listen sockets to resolve
+ * @Param all: listen sockets of every network namespace, to find the backend of forwarder
+ * @Return map[string]ListenProcess: listen process by key
+ * @Return error:
+ */
+func resolveListenSockets(ctx context.Context, sockets []ListenSocket, all []ListenSocket) (map[string]ListenProcess, error) {
+	processList, err := resolveSocketProcesses(ctx, sockets)
 	if err != nil {
 		return nil, err
 	}
-	ret := make(map[string][]inodeMap)
+	resolveForwarders(ctx, processList, all)
+	return processList, nil
+}
 
-	for _, pid := range pids {
-		t, err := getProcInodes(root, pid, max)
-		if err != nil {
-			// skip if permission error or no longer exists
-			if os.IsPermission(err) || os.IsNotExist(err) || err == io.EOF {
-				continue
-			}
-			return ret, err
-		}
-		if len(t) == 0 {
-			continue
-		}
-		// TODO: update ret.
-		ret = combineMap(ret, t)
+/*
+ * @Description: resolve listen sockets of every socket table to processes with one pass of /proc/<pid>/fd
+ * @Param ctx:
+ * @Param sockets: listen sockets found by discoverer
+ * @Return map[string]ListenProcess: listen process by key
+ * @Return error:
+ */
+func resolveSocketProcesses(ctx context.Context, sockets []ListenSocket) (map[string]ListenProcess, error) {
+	wanted := make(map[string]struct{}, len(sockets))
+	for _, s := range sockets {
+		wanted[s.Inode] = struct{}{}
 	}
-	return ret, nil
+	inodes, err := getProcInodesWanted(ctx, comm.ProcDir(), wanted)
+	if err != nil {
+		return nil, err
+	}
+	processList := make(map[string]ListenProcess, len(sockets))
+	for _, s := range sockets {
+		lp := s.ListenProcess
+		lp.Processes = socketProcesses(inodes[s.Inode])
+		lp.Inodes = []string{s.Inode}
+		// SO_REUSEPORT sockets share the same address
+		processList[lp.Key()] = mergeListenProcess(processList[lp.Key()], lp)
+	}
+	assignProcessRole(processList)
+	return processList, nil
 }
 
 /*
@@ -208,7 +361,7 @@ func getProcInodes(root string, pid int32, max int) (map[string][]inodeMap, erro
 func PidsWithContext(ctx context.Context) ([]int32, error) {
 	var ret []int32
 
-	d, err := os.Open(LinuxProcDir)
+	d, err := os.Open(comm.ProcDir())
 	if err != nil {
 		return nil, err
 	}
@@ -229,21 +382,3 @@ func PidsWithContext(ctx context.Context) ([]int32, error) {
 
 	return ret, nil
 }
-
-/*
- * @Description: 合并map信息
- * @Param src: 源inode信息
- * @Param add: 需要增加的inode信息
- * @Return map[string][]inodeMap: 结果信息
- */
-func combineMap(src map[string][]inodeMap, add map[string][]inodeMap) map[string][]inodeMap {
-	for key, value := range add {
-		a, exists := src[key]
-		if !exists {
-			src[key] = value
-			continue
-		}
-		src[key] = append(a, value...)
-	}
-	return src
-}
@@ -0,0 +1,103 @@
+package listen_process
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+// fake /proc tree of mysqld 1234 and its worker 1240 sharing 127.0.0.1:3306
+const fixtureProcDir = "../testdata/proc"
+
+func TestGetListenIPVxService(t *testing.T) {
+	tests := []struct {
+		protocol string
+		family   uint32
+		file     string
+		want     []ListenSocket
+	}{
+		{protocol: ProtocolTCP, family: syscall.AF_INET, file: "tcp", want: []ListenSocket{
+			{ListenProcess: ListenProcess{IP: "127.0.0.1", Port: 3306, Protocol: ProtocolTCP, State: TcpListen, AcceptQueue: 2}, Inode: "20001"},
+			{ListenProcess: ListenProcess{IP: "0.0.0.0", Port: 22, Protocol: ProtocolTCP, State: TcpListen}, Inode: "20002"},
+		}},
+		{protocol: ProtocolTCP, family: syscall.AF_INET6, file: "tcp6", want: []ListenSocket{
+			{ListenProcess: ListenProcess{IP: "::", Port: 8080, Protocol: ProtocolTCP, State: TcpListen}, Inode: "20011"},
+			{ListenProcess: ListenProcess{IP: "::1", Port: 3306, Protocol: ProtocolTCP, State: TcpListen}, Inode: "20012"},
+		}},
+		{protocol: ProtocolUDP, family: syscall.AF_INET, file: "udp", want: []ListenSocket{
+			{ListenProcess: ListenProcess{IP: "0.0.0.0", Port: 53, Protocol: ProtocolUDP, State: TcpClose, RxQueue: 512, Drops: 5}, Inode: "20021"},
+		}},
+		{protocol: ProtocolUDP, family: syscall.AF_INET6, file: "udp6", want: []ListenSocket{
+			{ListenProcess: ListenProcess{IP: "2400:8500:1301:1052:a157:7:154:23f", Port: 53, Protocol: ProtocolUDP, State: TcpClose}, Inode: "20023"},
+		}},
+	}
+	for _, tt := range tests {
+		got, err := getListenIPVxService(context.Background(), tt.protocol, tt.family,
+			filepath.Join(fixtureProcDir, "net", tt.file), true)
+		if err != nil {
+			t.Errorf("read %s error: %v", tt.file, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("listen sockets of %s = %+v, want %+v", tt.file, got, tt.want)
+		}
+	}
+}
+
+func TestGetListenUnixService(t *testing.T) {
+	got, err := getListenUnixService(context.Background(), filepath.Join(fixtureProcDir, "net", "unix"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// connected stream socket and unbound socket are not listening
+	want := []ListenSocket{
+		{ListenProcess: ListenProcess{Protocol: ProtocolUnix, Path: "/run/mysqld/mysqld.sock"}, Inode: "20031"},
+		{ListenProcess: ListenProcess{Protocol: ProtocolUnix, Path: "@mysqlx"}, Inode: "20032"},
+		{ListenProcess: ListenProcess{Protocol: ProtocolUnix, Path: "/run/systemd/notify"}, Inode: "20034"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unix listen sockets = %+v, want %+v", got, want)
+	}
+}
+
+func TestResolveSocketProcesses(t *testing.T) {
+	useProcDir(t, fixtureProcDir)
+	useScanAllHolders(t)
+	ns := netNamespace{inode: 4026531992, netDir: filepath.Join(fixtureProcDir, "net")}
+	sockets, err := collectNetnsListenProcess(context.Background(), ns, netFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sockets) != 9 {
+		t.Fatalf("found %d listen sockets, want 9", len(sockets))
+	}
+	processList, err := resolveSocketProcesses(context.Background(), sockets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mysqld := processList[ListenProcessKey(ns.inode, ProtocolTCP, "127.0.0.1", 3306)]
+	wantProcesses := []SocketProcess{
+		{Pid: 1234, Ppid: 1, Role: RoleMaster, StartTime: 321104591},
+		{Pid: 1240, Ppid: 1234, Role: RoleWorker, StartTime: 321104700},
+	}
+	if mysqld.Pid != 1234 || !reflect.DeepEqual(mysqld.Processes, wantProcesses) {
+		t.Errorf("listen process of 127.0.0.1:3306 = pid %d %+v, want pid 1234 %+v", mysqld.Pid, mysqld.Processes, wantProcesses)
+	}
+	if !reflect.DeepEqual(mysqld.Inodes, []string{"20001"}) || mysqld.Netns != ns.inode {
+		t.Errorf("listen process of 127.0.0.1:3306 inodes %v netns %d", mysqld.Inodes, mysqld.Netns)
+	}
+
+	sock := processList[UnixListenProcessKey(ns.inode, "/run/mysqld/mysqld.sock")]
+	if sock.Pid != 1234 || len(sock.Processes) != 1 {
+		t.Errorf("listen process of mysqld.sock = pid %d %+v, want pid 1234", sock.Pid, sock.Processes)
+	}
+
+	// socket without process holding it in the fixture
+	ssh, ok := processList[ListenProcessKey(ns.inode, ProtocolTCP, "0.0.0.0", 22)]
+	if !ok || ssh.Pid != 0 || len(ssh.Processes) != 0 {
+		t.Errorf("listen process of 0.0.0.0:22 = %+v, want without process", ssh)
+	}
+}
@@ -0,0 +1,209 @@
+// Package listen_process
+// @Description: query socket through NETLINK_SOCK_DIAG
+package listen_process
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+)
+
+const (
+	// see include/uapi/linux/sock_diag.h and include/uapi/linux/inet_diag.h
+	sockDiagByFamily = 20
+	inetDiagInfo     = 2
+
+	sizeofInetDiagReqV2 = 56
+	sizeofInetDiagMsg   = 72
+	sizeofRtAttr        = 4
+)
+
+/*
+ *  @Description: struct inet_diag_sockid
+ */
+type inetDiagSockID struct {
+	SPort  [2]byte // big endian
+	DPort  [2]byte // big endian
+	Src    [16]byte
+	Dst    [16]byte
+	If     uint32
+	Cookie [2]uint32
+}
+
+/*
+ *  @Description: struct inet_diag_req_v2
+ */
+type inetDiagReqV2 struct {
+	Family   uint8
+	Protocol uint8
+	Ext      uint8
+	Pad      uint8
+	States   uint32
+	ID       inetDiagSockID
+}
+
+/*
+ *  @Description: struct inet_diag_msg
+ */
+type inetDiagMsg struct {
+	Family  uint8
+	State   uint8
+	Timer   uint8
+	Retrans uint8
+	ID      inetDiagSockID
+	Expires uint32
+	RQueue  uint32
+	WQueue  uint32
+	UID     uint32
+	Inode   uint32
+}
+
+/*
+ *  @Description: socket returned by sock_diag
+ */
+type sockDiagEntry struct {
+	msg   inetDiagMsg
+	attrs map[uint16][]byte // rtattr following inet_diag_msg, such as INET_DIAG_INFO
+}
+
+/*
+ *  @Description: local address of socket
+ */
+func (e sockDiagEntry) local() Addr {
+	return Addr{IP: diagIP(e.msg.Family, e.msg.ID.Src), Port: uint32(binary.BigEndian.Uint16(e.msg.ID.SPort[:]))}
+}
+
+/*
+ *  @Description: remote address of socket
+ */
+func (e sockDiagEntry) remote() Addr {
+	return Addr{IP: diagIP(e.msg.Family, e.msg.ID.Dst), Port: uint32(binary.BigEndian.Uint16(e.msg.ID.DPort[:]))}
+}
+
+func diagIP(family uint8, b [16]byte) string {
+	if family == syscall.AF_INET {
+		return net.IP(b[:4]).String()
+	}
+	return net.IP(b[:]).String()
+}
+
+/*
+ * @Description: bit mask of tcp states for idiag_states
+ * @Param states: tcp state
+ * @Return uint32:
+ */
+func tcpStateMask(states ...TcpState) uint32 {
+	var mask uint32
+	for _, s := range states {
+		mask |= 1 << uint(s)
+	}
+	return mask
+}
+
+/*
+ * @Description: dump sockets through NETLINK_SOCK_DIAG
+ * @Param family: AF_INET or AF_INET6
+ * @Param protocol: IPPROTO_TCP or IPPROTO_UDP
+ * @Param states: bit mask of tcp state, see tcpStateMask
+ * @Param ext: bit mask of extension, such as 1<<(INET_DIAG_INFO-1)
+ * @Param sport: local port in host order, 0 means all. The kernel only dumps sockets bound to the port
+ * @Return []sockDiagEntry:
+ * @Return error:
+ */
+func sockDiagDump(family uint8, protocol uint8, states uint32, ext uint8, sport uint32) ([]sockDiagEntry, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, syscall.NETLINK_INET_DIAG)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Close(fd)
+	if err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	req := inetDiagReqV2{Family: family, Protocol: protocol, Ext: ext, States: states}
+	binary.BigEndian.PutUint16(req.ID.SPort[:], uint16(sport))
+	buf := bytes.NewBuffer(make([]byte, 0, syscall.NLMSG_HDRLEN+sizeofInetDiagReqV2))
+	_ = binary.Write(buf, binary.NativeEndian, syscall.NlMsghdr{
+		Len:   uint32(syscall.NLMSG_HDRLEN + sizeofInetDiagReqV2),
+		Type:  sockDiagByFamily,
+		Flags: syscall.NLM_F_REQUEST | syscall.NLM_F_DUMP,
+		Seq:   1,
+	})
+	_ = binary.Write(buf, binary.NativeEndian, req)
+	if err = syscall.Sendto(fd, buf.Bytes(), 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	var ret []sockDiagEntry
+	rb := make([]byte, 32*1024)
+	for {
+		n, _, err := syscall.Recvfrom(fd, rb, 0)
+		if err != nil {
+			return ret, err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(rb[:n])
+		if err != nil {
+			return ret, err
+		}
+		for _, m := range msgs {
+			switch m.Header.Type {
+			case syscall.NLMSG_DONE:
+				return ret, nil
+			case syscall.NLMSG_ERROR:
+				if len(m.Data) >= 4 {
+					if errno := -int32(binary.NativeEndian.Uint32(m.Data[:4])); errno != 0 {
+						return ret, syscall.Errno(errno)
+					}
+				}
+				return ret, errors.New("sock_diag: netlink error")
+			}
+			entry, err := parseSockDiagMsg(m.Data)
+			if err != nil {
+				return ret, err
+			}
+			// guard against kernel ignoring the port
+			if sport != 0 && entry.local().Port != sport {
+				continue
+			}
+			ret = append(ret, entry)
+		}
+	}
+}
+
+/*
+ * @Description: parse inet_diag_msg and its rtattr
+ * @Param data: payload of netlink message
+ * @Return sockDiagEntry:
+ * @Return error:
+ */
+func parseSockDiagMsg(data []byte) (sockDiagEntry, error) {
+	var entry sockDiagEntry
+	if len(data) < sizeofInetDiagMsg {
+		return entry, fmt.Errorf("sock_diag: short message %d", len(data))
+	}
+	if err := binary.Read(bytes.NewReader(data[:sizeofInetDiagMsg]), binary.NativeEndian, &entry.msg); err != nil {
+		return entry, err
+	}
+	attrs := data[sizeofInetDiagMsg:]
+	for len(attrs) >= sizeofRtAttr {
+		l := int(binary.NativeEndian.Uint16(attrs[0:2]))
+		t := binary.NativeEndian.Uint16(attrs[2:4])
+		if l < sizeofRtAttr || l > len(attrs) {
+			break
+		}
+		if entry.attrs == nil {
+			entry.attrs = make(map[uint16][]byte)
+		}
+		entry.attrs[t] = attrs[sizeofRtAttr:l]
+		// rtattr is aligned to 4 bytes
+		l = (l + syscall.RTA_ALIGNTO - 1) & ^(syscall.RTA_ALIGNTO - 1)
+		if l > len(attrs) {
+			break
+		}
+		attrs = attrs[l:]
+	}
+	return entry, nil
+}
@@ -0,0 +1,127 @@
+// Package listen_process
+// @Description: line of socket table in /proc/net/tcp and /proc/net/udp
+package listen_process
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"listen_process_exporter/comm"
+)
+
+/*
+ *  @Description: decoded line of /proc/net/tcp or /proc/net/udp
+ */
+type socketLine struct {
+	local      Addr
+	remote     string // hex remote address, decode on demand
+	remoteAddr Addr   // remote address decoded by sock_diag, remote is empty
+	state      TcpState
+	rxQueue    uint64
+	inode      string
+	drops      uint64 // udp only
+	backlog    uint64 // tcp listen socket only, reported by sock_diag
+}
+
+/*
+ * @Description: parse line of /proc/net/tcp or /proc/net/udp
+ *   sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ... drops
+ * @Param protocol: tcp or udp
+ * @Param family: AF_INET or AF_INET6
+ * @Param l: fields of the line
+ * @Return socketLine:
+ * @Return error:
+ */
+func parseSocketLine(protocol string, family uint32, l []string) (sl socketLine, err error) {
+	if len(l) < 10 {
+		return sl, fmt.Errorf("invalid socket line, %v", l)
+	}
+	if sl.state, err = parseTcpState(l[3]); err != nil {
+		return
+	}
+	if sl.local, err = decodeAddress(family, l[1]); err != nil {
+		return
+	}
+	q := strings.Split(l[4], ":")
+	if len(q) != 2 {
+		return sl, fmt.Errorf("invalid queue, %s", l[4])
+	}
+	if sl.rxQueue, err = strconv.ParseUint(q[1], 16, 64); err != nil {
+		return
+	}
+	if protocol == ProtocolUDP && len(l) > 12 {
+		sl.drops, _ = strconv.ParseUint(l[12], 10, 64)
+	}
+	sl.remote = l[2]
+	sl.inode = l[9]
+	return sl, nil
+}
+
+/*
+ *  @Description: listening tcp socket or unconnected udp socket
+ */
+func (sl socketLine) isListen(protocol string) bool {
+	if protocol == ProtocolUDP {
+		return sl.state == TcpClose
+	}
+	return sl.state == TcpListen
+}
+
+/*
+ *  @Description: remote address of the socket, decoded on demand when read from /proc/net/tcp
+ */
+func (sl socketLine) remoteAddress(family uint32) (Addr, error) {
+	if sl.remote == "" {
+		return sl.remoteAddr, nil
+	}
+	return decodeAddress(family, sl.remote)
+}
+
+/*
+ *  @Description: set queue of listen process from socket line
+ */
+func (sl socketLine) setQueue(lp *ListenProcess) {
+	if lp.Protocol == ProtocolUDP {
+		lp.RxQueue = sl.rxQueue
+		lp.Drops = sl.drops
+		return
+	}
+	// for listening socket rx_queue is the accept queue,
+	// the backlog is not in /proc/net/tcp but reported by sock_diag, see RefreshSocketQueue
+	lp.AcceptQueue = sl.rxQueue
+	lp.Backlog = sl.backlog
+}
+
+/*
+ * @Description: read socket tables of protocols and call fn for every listen socket, or every connection
+ * @Param ctx: tables shared by WithSocketTables are read only once
+ * @Param netDir: socket tables of network namespace, such as /proc/net or /proc/<pid>/net
+ * @Param protocols: tcp and/or udp
+ * @Param listen: listening tcp socket and unconnected udp socket, otherwise connections
+ * @Param fn: called with the table and decoded line
+ */
+func scanSocketTable(ctx context.Context, netDir string, protocols map[string]bool, listen bool,
+	fn func(nf netFile, sl socketLine)) {
+	tables := socketTablesFrom(ctx)
+	for _, nf := range netFiles {
+		if !protocols[nf.protocol] {
+			continue
+		}
+		lines, err := tables.tableLines(netDir, nf, listen)
+		if err != nil {
+			if comm.Debug() {
+				log.Printf("read socket table %s error %v", filepath.Join(netDir, nf.name), err)
+			}
+			continue
+		}
+		for _, sl := range lines {
+			if sl.isListen(nf.protocol) == listen {
+				fn(nf, sl)
+			}
+		}
+	}
+}
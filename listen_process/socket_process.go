@@ -0,0 +1,167 @@
+// Package listen_process
+// @Description: processes sharing listen socket
+package listen_process
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"listen_process_exporter/comm"
+)
+
+const (
+	RoleMaster = "master"
+	RoleWorker = "worker"
+)
+
+/*
+ *  @Description: process holding listen socket, pre-fork servers share the socket between master and workers
+ */
+type SocketProcess struct {
+	Pid       int32  `json:"pid"`
+	Ppid      int32  `json:"ppid"`
+	Role      string `json:"role"`
+	StartTime uint64 `json:"start_time"` // clock ticks after boot, tells reused pid apart
+}
+
+/*
+ * @Description: distinct processes holding the socket
+ * @Param holders: fd of processes holding the socket inode
+ * @Return []SocketProcess: sorted by pid
+ */
+func socketProcesses(holders []inodeMap) []SocketProcess {
+	var ret []SocketProcess
+	seen := make(map[int32]struct{}, len(holders))
+	for _, h := range holders {
+		if _, ok := seen[h.pid]; ok {
+			continue
+		}
+		seen[h.pid] = struct{}{}
+		ret = append(ret, SocketProcess{Pid: h.pid})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Pid < ret[j].Pid
+	})
+	return ret
+}
+
+/*
+ * @Description: merge listen sockets bound to the same address, such as SO_REUSEPORT
+ * @Param dst: listen process already found, may be empty
+ * @Param src: listen process to add
+ * @Return ListenProcess:
+ */
+func mergeListenProcess(dst ListenProcess, src ListenProcess) ListenProcess {
+	if dst.Protocol == "" {
+		return src
+	}
+	dst.RxQueue += src.RxQueue
+	dst.Drops += src.Drops
+	dst.AcceptQueue += src.AcceptQueue
+	dst.Backlog += src.Backlog
+	dst.Inodes = append(dst.Inodes, src.Inodes...)
+	seen := make(map[int32]struct{}, len(dst.Processes))
+	for _, p := range dst.Processes {
+		seen[p.Pid] = struct{}{}
+	}
+	for _, p := range src.Processes {
+		if _, ok := seen[p.Pid]; !ok {
+			dst.Processes = append(dst.Processes, p)
+		}
+	}
+	sort.Slice(dst.Processes, func(i, j int) bool {
+		return dst.Processes[i].Pid < dst.Processes[j].Pid
+	})
+	return dst
+}
+
+/*
+ * @Description: set role of processes holding the same socket,
+ *               process whose parent also holds the socket is worker, otherwise master.
+ *               Pid of listen process is set to the first master.
+ * @Param processList: listen process found
+ */
+func assignProcessRole(processList map[string]ListenProcess) {
+	stats := make(map[int32]processStat)
+	for k, lp := range processList {
+		holders := make(map[int32]struct{}, len(lp.Processes))
+		for _, p := range lp.Processes {
+			holders[p.Pid] = struct{}{}
+		}
+		lp.Pid = 0
+		for i, p := range lp.Processes {
+			stat, ok := stats[p.Pid]
+			if !ok {
+				stat, _ = readProcessStat(p.Pid)
+				stats[p.Pid] = stat
+			}
+			lp.Processes[i].Ppid = stat.ppid
+			lp.Processes[i].StartTime = stat.startTime
+			if _, ok := holders[stat.ppid]; ok {
+				lp.Processes[i].Role = RoleWorker
+				continue
+			}
+			lp.Processes[i].Role = RoleMaster
+			if lp.Pid == 0 {
+				lp.Pid = p.Pid
+			}
+		}
+		processList[k] = lp
+	}
+}
+
+/*
+ *  @Description: fields of /proc/<pid>/stat used to find master process and reused pid
+ */
+type processStat struct {
+	ppid      int32
+	startTime uint64
+}
+
+/*
+ * @Description: read /proc/<pid>/stat field ppid(4) and starttime(22)
+ * @Param pid:
+ * @Return processStat:
+ * @Return error:
+ */
+func readProcessStat(pid int32) (processStat, error) {
+	var stat processStat
+	b, err := ioutil.ReadFile(fmt.Sprintf("%s/%d/stat", comm.ProcDir(), pid))
+	if err != nil {
+		return stat, err
+	}
+	// comm may contain space and ')'
+	s := string(b)
+	i := strings.LastIndex(s, ")")
+	if i < 0 {
+		return stat, fmt.Errorf("invalid stat of pid %d", pid)
+	}
+	// fields after comm start from state(3)
+	f := strings.Fields(s[i+1:])
+	if len(f) < 20 {
+		return stat, fmt.Errorf("invalid stat of pid %d", pid)
+	}
+	ppid, err := strconv.ParseInt(f[1], 10, 32)
+	if err != nil {
+		return stat, err
+	}
+	stat.ppid = int32(ppid)
+	if stat.startTime, err = strconv.ParseUint(f[19], 10, 64); err != nil {
+		return stat, err
+	}
+	return stat, nil
+}
+
+/*
+ * @Description: read parent pid from /proc/<pid>/stat field ppid(4)
+ * @Param pid:
+ * @Return int32: parent pid
+ * @Return error:
+ */
+func readProcessPpid(pid int32) (int32, error) {
+	stat, err := readProcessStat(pid)
+	return stat.ppid, err
+}
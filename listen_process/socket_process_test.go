@@ -0,0 +1,68 @@
+package listen_process
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAssignProcessRoleFixture(t *testing.T) {
+	useProcDir(t, fixtureProcDir)
+	processList := map[string]ListenProcess{
+		"mysqld": {Processes: []SocketProcess{{Pid: 1234}, {Pid: 1240}}},
+		// worker holding a socket its master does not hold
+		"worker": {Processes: []SocketProcess{{Pid: 1240}}},
+	}
+	assignProcessRole(processList)
+	master := SocketProcess{Pid: 1234, Ppid: 1, Role: RoleMaster, StartTime: 321104591}
+	worker := SocketProcess{Pid: 1240, Ppid: 1234, Role: RoleWorker, StartTime: 321104700}
+	if lp := processList["mysqld"]; lp.Pid != 1234 || !reflect.DeepEqual(lp.Processes, []SocketProcess{master, worker}) {
+		t.Errorf("mysqld pid %d processes %+v, want master 1234 and worker 1240", lp.Pid, lp.Processes)
+	}
+	worker.Role = RoleMaster
+	if lp := processList["worker"]; lp.Pid != 1240 || !reflect.DeepEqual(lp.Processes, []SocketProcess{worker}) {
+		t.Errorf("worker pid %d processes %+v, want 1240 as master", lp.Pid, lp.Processes)
+	}
+}
+
+func TestAssignProcessRole(t *testing.T) {
+	writeFakeProc(t,
+		// nginx master and workers
+		fakeProc{pid: 300, ppid: 1, comm: "nginx"},
+		fakeProc{pid: 301, ppid: 300, comm: "nginx"},
+		fakeProc{pid: 302, ppid: 300, comm: "nginx"},
+		// worker forked by a worker
+		fakeProc{pid: 303, ppid: 302, comm: "nginx"},
+		// another service sharing the port with SO_REUSEPORT
+		fakeProc{pid: 400, ppid: 1, comm: "envoy"},
+	)
+	tests := []struct {
+		name  string
+		pids  []int32
+		pid   int32
+		roles []string
+	}{
+		{name: "prefork", pids: []int32{300, 301, 302, 303}, pid: 300,
+			roles: []string{RoleMaster, RoleWorker, RoleWorker, RoleWorker}},
+		{name: "reuseport", pids: []int32{300, 301, 400}, pid: 300, roles: []string{RoleMaster, RoleWorker, RoleMaster}},
+		// master closed the socket after fork
+		{name: "workers only", pids: []int32{301, 302}, pid: 301, roles: []string{RoleMaster, RoleMaster}},
+		// holder exited after the scan
+		{name: "exited", pids: []int32{300, 999}, pid: 300, roles: []string{RoleMaster, RoleMaster}},
+	}
+	for _, tt := range tests {
+		lp := ListenProcess{Pid: 1}
+		for _, pid := range tt.pids {
+			lp.Processes = append(lp.Processes, SocketProcess{Pid: pid})
+		}
+		processList := map[string]ListenProcess{tt.name: lp}
+		assignProcessRole(processList)
+		lp = processList[tt.name]
+		var roles []string
+		for _, p := range lp.Processes {
+			roles = append(roles, p.Role)
+		}
+		if lp.Pid != tt.pid || !reflect.DeepEqual(roles, tt.roles) {
+			t.Errorf("%s pid %d roles %v, want %d %v", tt.name, lp.Pid, roles, tt.pid, tt.roles)
+		}
+	}
+}
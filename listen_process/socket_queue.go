@@ -0,0 +1,84 @@
+// Package listen_process
+// @Description: read queue of listen socket at scrape time
+package listen_process
+
+import (
+	"context"
+	"log"
+	"syscall"
+
+	"listen_process_exporter/comm"
+)
+
+/*
+ * @Description: re-read queue of listen sockets, the cache is only refreshed every refresh interval
+ *               but accept queue and udp drops must be fresh on every scrape
+ * @Param ctx: socket tables shared by WithSocketTables are not read again
+ * @Param lps: listen process to update
+ * @Return []ListenProcess: listen process with current queue
+ */
+func RefreshSocketQueue(ctx context.Context, lps []ListenProcess) []ListenProcess {
+	wanted := make(map[string]int, len(lps))
+	protocols := make(map[string]bool)
+	for i, lp := range lps {
+		if lp.Protocol != ProtocolTCP && lp.Protocol != ProtocolUDP {
+			continue
+		}
+		wanted[lp.Key()] = i
+		protocols[lp.Protocol] = true
+	}
+	if len(wanted) == 0 {
+		return lps
+	}
+	ret := make([]ListenProcess, len(lps))
+	copy(ret, lps)
+	for _, i := range wanted {
+		ret[i].AcceptQueue, ret[i].Backlog, ret[i].RxQueue, ret[i].Drops = 0, 0, 0, 0
+	}
+	for netns, dir := range netDirs(ret) {
+		netns := netns
+		scanSocketTable(ctx, dir, protocols, true, func(nf netFile, sl socketLine) {
+			i, ok := wanted[ListenProcessKey(netns, nf.protocol, sl.local.IP, sl.local.Port)]
+			if !ok {
+				return
+			}
+			// SO_REUSEPORT sockets share the same address
+			var q ListenProcess
+			q.Protocol = nf.protocol
+			sl.setQueue(&q)
+			ret[i].AcceptQueue += q.AcceptQueue
+			ret[i].Backlog += q.Backlog
+			ret[i].RxQueue += q.RxQueue
+			ret[i].Drops += q.Drops
+		})
+	}
+	if protocols[ProtocolTCP] && !useSockDiag() {
+		// sock_diag already reported the backlog with the queue
+		refreshBacklog(ret, wanted)
+	}
+	return ret
+}
+
+/*
+ * @Description: max backlog of listen socket is only reported by sock_diag as idiag_wqueue,
+ *               which only sees the network namespace of this exporter
+ * @Param lps: listen process to update
+ * @Param wanted: index of listen process by key
+ */
+func refreshBacklog(lps []ListenProcess, wanted map[string]int) {
+	for _, family := range []uint8{syscall.AF_INET, syscall.AF_INET6} {
+		entries, err := sockDiagDump(family, syscall.IPPROTO_TCP, tcpStateMask(TcpListen), 0, 0)
+		if err != nil {
+			if comm.Debug() {
+				log.Printf("query listen backlog through sock_diag error %v", err)
+			}
+			return
+		}
+		for _, entry := range entries {
+			local := entry.local()
+			if i, ok := wanted[ListenProcessKey(selfNetns(), ProtocolTCP, local.IP, local.Port)]; ok {
+				lps[i].Backlog += uint64(entry.msg.WQueue)
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+// Package listen_process
+// @Description: socket tables read once per scrape and shared by every reader
+package listen_process
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"listen_process_exporter/comm"
+)
+
+type socketTablesKey struct{}
+
+/*
+ *  @Description: parsed socket tables by path, such as /proc/<pid>/net/tcp, or by sock_diag dump.
+ *                Validating the cache, refreshing the queue and counting connections of one scrape
+ *                read every table only once
+ */
+type socketTables struct {
+	lock  sync.Mutex
+	lines map[string][]socketLine
+	unix  map[string][]ListenSocket
+}
+
+/*
+ * @Description: share socket tables between readers with the context, such as during one probe.
+ *               Without it every reader reads the tables again
+ * @Param ctx:
+ * @Return context.Context:
+ */
+func WithSocketTables(ctx context.Context) context.Context {
+	return context.WithValue(ctx, socketTablesKey{}, &socketTables{
+		lines: make(map[string][]socketLine),
+		unix:  make(map[string][]ListenSocket),
+	})
+}
+
+/*
+ *  @Description: shared socket tables of the context, nil when not shared
+ */
+func socketTablesFrom(ctx context.Context) *socketTables {
+	t, _ := ctx.Value(socketTablesKey{}).(*socketTables)
+	return t
+}
+
+/*
+ * @Description: lines of socket table, dumped through sock_diag when the netlink backend is selected and
+ *               the table is of the network namespace of this exporter, otherwise read from the table file
+ * @Param netDir: socket tables of network namespace, such as /proc/net or /proc/<pid>/net
+ * @Param nf: protocol and family of the table
+ * @Param listen: listen sockets only, otherwise connections only, sock_diag only dumps the wanted states
+ * @Return []socketLine:
+ * @Return error:
+ */
+func (t *socketTables) tableLines(netDir string, nf netFile, listen bool) ([]socketLine, error) {
+	if useSockDiag() && netDir == selfNetDir() {
+		lines, err := t.cached(fmt.Sprintf("sock_diag:%s:%t", nf.name, listen), func() ([]socketLine, error) {
+			return diagSocketLines(nf, listen)
+		})
+		if err == nil {
+			return lines, nil
+		}
+		if comm.Debug() {
+			log.Printf("dump socket %s through sock_diag error, fall back to procfs: %v", nf.name, err)
+		}
+	}
+	file := filepath.Join(netDir, nf.name)
+	return t.cached(file, func() ([]socketLine, error) {
+		return readSocketLines(file, nf)
+	})
+}
+
+/*
+ * @Description: lines read by read, read once when shared
+ * @Param key: path of the table, or the sock_diag dump
+ * @Param read:
+ * @Return []socketLine:
+ * @Return error:
+ */
+func (t *socketTables) cached(key string, read func() ([]socketLine, error)) ([]socketLine, error) {
+	if t != nil {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		if lines, ok := t.lines[key]; ok {
+			return lines, nil
+		}
+	}
+	lines, err := read()
+	if err != nil {
+		return nil, err
+	}
+	if t != nil {
+		t.lines[key] = lines
+	}
+	return lines, nil
+}
+
+/*
+ * @Description: parsed lines of /proc/net/tcp or /proc/net/udp
+ * @Param file: path of the table
+ * @Param nf: protocol and family of the table
+ * @Return []socketLine:
+ * @Return error:
+ */
+func readSocketLines(file string, nf netFile) ([]socketLine, error) {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var lines []socketLine
+	// skip first line
+	for _, line := range bytes.Split(contents, []byte("\n"))[1:] {
+		sl, err := parseSocketLine(nf.protocol, nf.family, strings.Fields(string(line)))
+		if err != nil {
+			continue
+		}
+		lines = append(lines, sl)
+	}
+	return lines, nil
+}
+
+/*
+ * @Description: listen sockets of /proc/net/unix, read once when shared
+ * @Param ctx:
+ * @Param file: path of the table
+ * @Return []ListenSocket:
+ * @Return error:
+ */
+func (t *socketTables) unixListenSockets(ctx context.Context, file string) ([]ListenSocket, error) {
+	if t != nil {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		if sockets, ok := t.unix[file]; ok {
+			return sockets, nil
+		}
+	}
+	sockets, err := getListenUnixService(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	if t != nil {
+		t.unix[file] = sockets
+	}
+	return sockets, nil
+}
@@ -0,0 +1,64 @@
+package listen_process
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+/*
+ *  @Description: copy socket tables of the fixture to a temp dir which the test can modify
+ */
+func copyNetDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range []string{"tcp", "tcp6", "udp", "udp6", "unix"} {
+		b, err := os.ReadFile(filepath.Join(fixtureProcDir, "net", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(dir, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func countSockets(ctx context.Context, dir string) int {
+	n := 0
+	for _, listen := range []bool{true, false} {
+		scanSocketTable(ctx, dir, map[string]bool{ProtocolTCP: true, ProtocolUDP: true}, listen, func(nf netFile, sl socketLine) {
+			n++
+		})
+	}
+	return n
+}
+
+func TestSocketTablesShared(t *testing.T) {
+	dir := copyNetDir(t)
+	ctx := WithSocketTables(context.Background())
+	if n := countSockets(ctx, dir); n != 9 {
+		t.Fatalf("found %d sockets, want 9", n)
+	}
+	sockets, err := socketTablesFrom(ctx).unixListenSockets(ctx, filepath.Join(dir, "unix"))
+	if err != nil || len(sockets) != 3 {
+		t.Fatalf("found %d unix listen sockets error %v, want 3", len(sockets), err)
+	}
+
+	// tables already read are not read again
+	for _, name := range []string{"tcp", "tcp6", "udp", "udp6", "unix"} {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countSockets(ctx, dir); n != 9 {
+		t.Errorf("found %d shared sockets, want 9", n)
+	}
+	if sockets, _ = socketTablesFrom(ctx).unixListenSockets(ctx, filepath.Join(dir, "unix")); len(sockets) != 3 {
+		t.Errorf("found %d shared unix listen sockets, want 3", len(sockets))
+	}
+	if n := countSockets(context.Background(), dir); n != 0 {
+		t.Errorf("found %d sockets without shared tables, want 0", n)
+	}
+}
@@ -0,0 +1,110 @@
+// Package listen_process
+// @Description: probe target
+package listen_process
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+/*
+ *  @Description: target of probe, such as 3306 udp/53 127.0.0.1:8080 or unix:/var/run/mysqld/mysqld.sock
+ */
+type Target struct {
+	Protocol string `json:"protocol"`
+	IP       string `json:"ip,omitempty"` // empty means any bind ip
+	Port     uint32 `json:"port"`
+	Path     string `json:"path,omitempty"`
+	Netns    uint64 `json:"netns,omitempty"` // inode of network namespace, 0 means any namespace
+}
+
+/*
+ * @Description: parse probe target
+ * @Param target: port or ip:port with optional protocol prefix, 3306 tcp/3306 udp/53 127.0.0.1:8080 [::1]:8080,
+ *                or unix socket path unix:/var/run/mysqld/mysqld.sock unix:@abstract
+ * @Return Target:
+ * @Return error:
+ */
+func ParseTarget(target string) (Target, error) {
+	if strings.HasPrefix(target, ProtocolUnix+":") {
+		path := strings.TrimPrefix(target, ProtocolUnix+":")
+		if path == "" {
+			return Target{}, fmt.Errorf("target unix socket path is required")
+		}
+		return Target{Protocol: ProtocolUnix, Path: path}, nil
+	}
+	t := Target{Protocol: ProtocolTCP}
+	if i := strings.Index(target, "/"); i >= 0 {
+		t.Protocol = strings.ToLower(target[:i])
+		target = target[i+1:]
+	}
+	if t.Protocol != ProtocolTCP && t.Protocol != ProtocolUDP {
+		return t, fmt.Errorf("target protocol[%s] must be tcp or udp", t.Protocol)
+	}
+	if strings.Contains(target, ":") {
+		host, port, err := net.SplitHostPort(target)
+		if err != nil {
+			return t, fmt.Errorf("target address[%s] invalid: %v", target, err)
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return t, fmt.Errorf("target ip[%s] invalid", host)
+		}
+		t.IP = ip.String()
+		target = port
+	}
+	port, err := strconv.ParseUint(target, 10, 16)
+	if err != nil {
+		return t, fmt.Errorf("target port[%s] must be number", target)
+	}
+	if port == 0 {
+		// no socket listens port 0
+		return t, fmt.Errorf("target port must not be 0")
+	}
+	t.Port = uint32(port)
+	return t, nil
+}
+
+/*
+ *  @Description: whether listen process is bound to the port of target in the network namespace of target, ignore bind ip
+ */
+func (t Target) matchPort(lp ListenProcess) bool {
+	if t.Netns != 0 && lp.Netns != t.Netns {
+		return false
+	}
+	if t.Protocol == ProtocolUnix {
+		return lp.Protocol == ProtocolUnix && lp.Path == t.Path
+	}
+	return lp.Protocol == t.Protocol && lp.Port == t.Port
+}
+
+/*
+ *  @Description: whether listen process accepts the ip of target, listen process bound to any address also accepts it
+ */
+func (t Target) matchIP(lp ListenProcess) (exact bool, wildcard bool) {
+	if t.Protocol == ProtocolUnix || t.IP == "" {
+		return true, false
+	}
+	if lp.IP == t.IP {
+		return true, false
+	}
+	return false, lp.IP == net.IPv4zero.String() || lp.IP == net.IPv6unspecified.String()
+}
+
+func (t Target) String() string {
+	var s string
+	switch {
+	case t.Protocol == ProtocolUnix:
+		s = ProtocolUnix + ":" + t.Path
+	case t.IP == "":
+		s = fmt.Sprintf("%s/%d", t.Protocol, t.Port)
+	default:
+		s = t.Protocol + "/" + net.JoinHostPort(t.IP, strconv.FormatUint(uint64(t.Port), 10))
+	}
+	if t.Netns != 0 {
+		s += fmt.Sprintf(" netns %d", t.Netns)
+	}
+	return s
+}
@@ -0,0 +1,315 @@
+// Package listen_process
+// @Description: discover processes of probe target
+package listen_process
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"listen_process_exporter/comm"
+)
+
+const (
+	SelectorPidfile = "pidfile:"
+	SelectorComm    = "comm:"
+	SelectorCmdline = "cmdline~"
+	SelectorExe     = "exe:"
+	SelectorCgroup  = "cgroup:"
+
+	// max number of ports in one probe
+	MaxTargetPorts = 1024
+)
+
+var (
+	ErrNotFound = errors.New("listen_process not found")
+)
+
+/*
+ *  @Description: find processes of probe target, every implementation feeds the same metrics.
+ *                Target finds processes by listen socket, processDiscoverer finds daemons listening nothing
+ */
+type Discoverer interface {
+	Discover(ctx context.Context) ([]ListenProcess, error)
+	String() string
+}
+
+/*
+ * @Description: parse probe target to discoverer
+ * @Param target: pidfile:/run/x.pid comm:mysqld cmdline~regex exe:/usr/sbin/nginx cgroup:/system.slice/foo.service,
+ *                otherwise listen address, see ParseTarget
+ * @Param netns: inode of network namespace, 0 means any
+ * @Return Discoverer:
+ * @Return error:
+ */
+func ParseDiscoverer(target string, netns uint64) (Discoverer, error) {
+	for _, prefix := range []string{SelectorPidfile, SelectorComm, SelectorCmdline, SelectorExe, SelectorCgroup} {
+		if target == prefix {
+			return nil, fmt.Errorf("target %s has empty selector", target)
+		}
+	}
+	d := processDiscoverer{selector: target, netns: netns}
+	switch {
+	case strings.HasPrefix(target, SelectorPidfile):
+		path := strings.TrimPrefix(target, SelectorPidfile)
+		d.pids = func(ctx context.Context) ([]int32, error) {
+			return readPidfile(path)
+		}
+		d.match = func(pid int32) bool { return true }
+	case strings.HasPrefix(target, SelectorComm):
+		name := strings.TrimPrefix(target, SelectorComm)
+		d.match = func(pid int32) bool {
+			b, err := ioutil.ReadFile(fmt.Sprintf("%s/%d/comm", comm.ProcDir(), pid))
+			return err == nil && strings.TrimSpace(string(b)) == name
+		}
+	case strings.HasPrefix(target, SelectorCmdline):
+		re, err := regexp.Compile(strings.TrimPrefix(target, SelectorCmdline))
+		if err != nil {
+			return nil, fmt.Errorf("cmdline regex invalid: %v", err)
+		}
+		d.match = func(pid int32) bool {
+			cmdline := readCmdline(pid)
+			// kernel thread has no cmdline
+			return cmdline != "" && re.MatchString(cmdline)
+		}
+	case strings.HasPrefix(target, SelectorExe):
+		path := strings.TrimPrefix(target, SelectorExe)
+		d.match = func(pid int32) bool {
+			exe, err := os.Readlink(fmt.Sprintf("%s/%d/exe", comm.ProcDir(), pid))
+			return err == nil && strings.TrimSuffix(exe, " (deleted)") == path
+		}
+	case strings.HasPrefix(target, SelectorCgroup):
+		path := strings.TrimSuffix(strings.TrimPrefix(target, SelectorCgroup), "/")
+		d.match = func(pid int32) bool {
+			return inCgroup(pid, path)
+		}
+	default:
+		t, err := ParseTarget(target)
+		if err != nil {
+			return nil, err
+		}
+		t.Netns = netns
+		return t, nil
+	}
+	return d, nil
+}
+
+/*
+ * @Description: parse probe target which may be a list of port ranges, such as 6379-6394 or 3306,3307,33060
+ * @Param target: comma separated listen address or port range, or one process selector, see ParseDiscoverer
+ * @Param netns: inode of network namespace, 0 means any
+ * @Return []Discoverer: distinct discoverer
+ * @Return error:
+ */
+func ParseDiscoverers(target string, netns uint64) ([]Discoverer, error) {
+	if isProcessSelector(target) || strings.HasPrefix(target, ProtocolUnix+":") {
+		// regex and path may contain comma and dash
+		d, err := ParseDiscoverer(target, netns)
+		if err != nil {
+			return nil, err
+		}
+		return []Discoverer{d}, nil
+	}
+	var ret []Discoverer
+	seen := make(map[string]struct{})
+	for _, elem := range strings.Split(target, ",") {
+		targets, err := parseTargetRange(strings.TrimSpace(elem))
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range targets {
+			t.Netns = netns
+			if _, ok := seen[t.String()]; ok {
+				continue
+			}
+			seen[t.String()] = struct{}{}
+			ret = append(ret, t)
+		}
+		if len(ret) > MaxTargetPorts {
+			return nil, fmt.Errorf("target has more than %d ports", MaxTargetPorts)
+		}
+	}
+	return ret, nil
+}
+
+/*
+ * @Description: parse listen address whose port may be a range, such as udp/53-60 or 127.0.0.1:8080-8090
+ * @Param target:
+ * @Return []Target:
+ * @Return error:
+ */
+func parseTargetRange(target string) ([]Target, error) {
+	i := strings.LastIndexAny(target, ":/")
+	prefix, ports := target[:i+1], target[i+1:]
+	lo, hi, ok := strings.Cut(ports, "-")
+	if !ok {
+		t, err := ParseTarget(target)
+		if err != nil {
+			return nil, err
+		}
+		return []Target{t}, nil
+	}
+	from, err := strconv.ParseUint(lo, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("target port[%s] must be number", lo)
+	}
+	to, err := strconv.ParseUint(hi, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("target port[%s] must be number", hi)
+	}
+	if from > to || to-from >= MaxTargetPorts {
+		return nil, fmt.Errorf("target port range[%s] invalid", ports)
+	}
+	var ret []Target
+	for port := from; port <= to; port++ {
+		t, err := ParseTarget(prefix + strconv.FormatUint(port, 10))
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, t)
+	}
+	return ret, nil
+}
+
+func isProcessSelector(target string) bool {
+	for _, prefix := range []string{SelectorPidfile, SelectorComm, SelectorCmdline, SelectorExe, SelectorCgroup} {
+		if strings.HasPrefix(target, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+ *  @Description: listen process of target
+ */
+func (t Target) Discover(ctx context.Context) ([]ListenProcess, error) {
+	return GetListenPortPid(ctx, t)
+}
+
+/*
+ *  @Description: find processes by pidfile, comm, cmdline, exe or cgroup.
+ *                Matched processes are reported as one listen process without socket
+ */
+type processDiscoverer struct {
+	selector string
+	netns    uint64
+	pids     func(ctx context.Context) ([]int32, error) // nil means every process
+	match    func(pid int32) bool
+}
+
+func (d processDiscoverer) String() string {
+	if d.netns != 0 {
+		return fmt.Sprintf("%s netns %d", d.selector, d.netns)
+	}
+	return d.selector
+}
+
+func (d processDiscoverer) Discover(ctx context.Context) ([]ListenProcess, error) {
+	pids, err := d.listPids(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var lp ListenProcess
+	for _, pid := range pids {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !d.match(pid) {
+			continue
+		}
+		if d.netns != 0 {
+			if inode, err := readNetns(fmt.Sprintf("%s/%d/ns/net", comm.ProcDir(), pid)); err != nil || inode != d.netns {
+				continue
+			}
+		}
+		lp.Processes = append(lp.Processes, SocketProcess{Pid: pid})
+	}
+	if len(lp.Processes) == 0 {
+		return nil, ErrNotFound
+	}
+	// same order as processes holding socket
+	sort.Slice(lp.Processes, func(i, j int) bool {
+		return lp.Processes[i].Pid < lp.Processes[j].Pid
+	})
+	processList := map[string]ListenProcess{d.selector: lp}
+	// process whose parent also matches is worker
+	assignProcessRole(processList)
+	lp = processList[d.selector]
+	lp.Netns, _ = readNetns(fmt.Sprintf("%s/%d/ns/net", comm.ProcDir(), lp.Pid))
+	return []ListenProcess{lp}, nil
+}
+
+func (d processDiscoverer) listPids(ctx context.Context) ([]int32, error) {
+	if d.pids != nil {
+		return d.pids(ctx)
+	}
+	return PidsWithContext(ctx)
+}
+
+/*
+ * @Description: read pid from pidfile, the process must be alive
+ * @Param path:
+ * @Return []int32:
+ * @Return error:
+ */
+func readPidfile(path string) ([]int32, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		// removed when the daemon stopped
+		return nil, fmt.Errorf("pidfile %s: %w", path, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("pidfile %s invalid: %v", path, err)
+	}
+	if _, err = os.Stat(fmt.Sprintf("%s/%d", comm.ProcDir(), pid)); err != nil {
+		return nil, fmt.Errorf("pid %d of pidfile %s not running: %w", pid, path, ErrNotFound)
+	}
+	return []int32{int32(pid)}, nil
+}
+
+/*
+ *  @Description: cmdline of process, arguments are joined with space
+ */
+func readCmdline(pid int32) string {
+	b, err := ioutil.ReadFile(fmt.Sprintf("%s/%d/cmdline", comm.ProcDir(), pid))
+	if err != nil {
+		return ""
+	}
+	return string(bytes.ReplaceAll(bytes.TrimRight(b, "\x00"), []byte{0}, []byte{' '}))
+}
+
+/*
+ * @Description: whether process is in the cgroup or its descendant, of cgroup v2 or any v1 hierarchy
+ * @Param pid:
+ * @Param path: cgroup path such as /system.slice/foo.service
+ * @Return bool:
+ */
+func inCgroup(pid int32, path string) bool {
+	b, err := ioutil.ReadFile(fmt.Sprintf("%s/%d/cgroup", comm.ProcDir(), pid))
+	if err != nil {
+		return false
+	}
+	// hierarchy-ID:controller-list:cgroup-path
+	for _, line := range strings.Split(string(b), "\n") {
+		f := strings.SplitN(line, ":", 3)
+		if len(f) != 3 {
+			continue
+		}
+		if f[2] == path || strings.HasPrefix(f[2], path+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package listen_process
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const fixtureRunDir = "../testdata/run"
+
+func TestReadPidfile(t *testing.T) {
+	useProcDir(t, fixtureProcDir)
+	tests := []struct {
+		file     string
+		want     []int32
+		notFound bool
+		err      string
+	}{
+		{file: "mysqld.pid", want: []int32{1234}},
+		// removed when the daemon stopped
+		{file: "missing.pid", notFound: true, err: "missing.pid"},
+		// daemon crashed without removing the pidfile
+		{file: "stale.pid", notFound: true, err: "pid 4321 of pidfile"},
+		{file: "malformed.pid", err: "invalid"},
+		{file: "empty.pid", err: "invalid"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(fixtureRunDir, tt.file)
+		got, err := readPidfile(path)
+		if tt.err == "" {
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readPidfile(%s) = %v, %v, want %v", tt.file, got, err, tt.want)
+			}
+			continue
+		}
+		if err == nil {
+			t.Errorf("readPidfile(%s) = %v, want error", tt.file, got)
+			continue
+		}
+		if errors.Is(err, ErrNotFound) != tt.notFound {
+			t.Errorf("readPidfile(%s) error %v wraps ErrNotFound = %v, want %v", tt.file, err, !tt.notFound, tt.notFound)
+		}
+		if !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("readPidfile(%s) error %v, want containing %q", tt.file, err, tt.err)
+		}
+	}
+}
+
+func TestInCgroup(t *testing.T) {
+	useProcDir(t, fixtureProcDir)
+	tests := []struct {
+		pid  int32
+		path string
+		want bool
+	}{
+		// cgroup v2
+		{pid: 1234, path: "/system.slice/mysql.service", want: true},
+		{pid: 1234, path: "/system.slice", want: true},
+		{pid: 1234, path: "/system.slice/mysql", want: false},
+		{pid: 1234, path: "/system.slice/mysql.service/worker", want: false},
+		// any v1 hierarchy
+		{pid: 1240, path: "/system.slice/mysql.service/worker", want: true},
+		{pid: 1240, path: "/system.slice/mysql.service", want: true},
+		{pid: 1240, path: "/user.slice", want: false},
+		// process exited
+		{pid: 4321, path: "/system.slice", want: false},
+	}
+	for _, tt := range tests {
+		if got := inCgroup(tt.pid, tt.path); got != tt.want {
+			t.Errorf("inCgroup(%d, %s) = %v, want %v", tt.pid, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestProcessDiscoverer(t *testing.T) {
+	useProcDir(t, fixtureProcDir)
+	master := SocketProcess{Pid: 1234, Ppid: 1, Role: RoleMaster, StartTime: 321104591}
+	worker := SocketProcess{Pid: 1240, Ppid: 1234, Role: RoleWorker, StartTime: 321104700}
+	// worker matched without its master
+	alone := SocketProcess{Pid: 1240, Ppid: 1234, Role: RoleMaster, StartTime: 321104700}
+	tests := []struct {
+		selector string
+		netns    uint64
+		want     []SocketProcess
+		err      error
+	}{
+		{selector: SelectorPidfile + filepath.Join(fixtureRunDir, "mysqld.pid"), want: []SocketProcess{master}},
+		{selector: SelectorPidfile + filepath.Join(fixtureRunDir, "missing.pid"), err: ErrNotFound},
+		{selector: SelectorPidfile + filepath.Join(fixtureRunDir, "stale.pid"), err: ErrNotFound},
+		{selector: SelectorComm + "mysqld", want: []SocketProcess{master}},
+		{selector: SelectorComm + "mysqld worker", want: []SocketProcess{alone}},
+		{selector: SelectorComm + "mysql", err: ErrNotFound},
+		{selector: SelectorCmdline + "^/usr/sbin/mysqld --user=mysql$", want: []SocketProcess{master, worker}},
+		{selector: SelectorCmdline + "postgres", err: ErrNotFound},
+		{selector: SelectorCgroup + "/system.slice/mysql.service/", want: []SocketProcess{master, worker}},
+		{selector: SelectorCgroup + "/system.slice/mysql.service/worker", want: []SocketProcess{alone}},
+		{selector: SelectorCgroup + "/system.slice/nginx.service", err: ErrNotFound},
+		{selector: SelectorComm + "mysqld", netns: 4026531992, want: []SocketProcess{master}},
+		{selector: SelectorComm + "mysqld", netns: 4026532000, err: ErrNotFound},
+	}
+	for _, tt := range tests {
+		d, err := ParseDiscoverer(tt.selector, tt.netns)
+		if err != nil {
+			t.Fatalf("ParseDiscoverer(%s) error: %v", tt.selector, err)
+		}
+		lps, err := d.Discover(context.Background())
+		if tt.err != nil {
+			if !errors.Is(err, tt.err) {
+				t.Errorf("%s discovered %v, %v, want %v", d, lps, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s error: %v", d, err)
+			continue
+		}
+		if len(lps) != 1 || lps[0].Netns != 4026531992 || !reflect.DeepEqual(lps[0].Processes, tt.want) {
+			t.Errorf("%s discovered %+v, want processes %+v", d, lps, tt.want)
+			continue
+		}
+		if lps[0].Pid != tt.want[0].Pid {
+			t.Errorf("%s pid = %d, want %d", d, lps[0].Pid, tt.want[0].Pid)
+		}
+	}
+}
+
+func TestParseDiscovererInvalid(t *testing.T) {
+	for _, target := range []string{SelectorPidfile, SelectorComm, SelectorCmdline, SelectorExe, SelectorCgroup,
+		SelectorCmdline + "mysqld(", "tcp/mysql"} {
+		if d, err := ParseDiscoverer(target, 0); err == nil {
+			t.Errorf("ParseDiscoverer(%s) = %v, want error", target, d)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package listen_process
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		target string
+		want   Target
+		err    bool
+	}{
+		{target: "3306", want: Target{Protocol: ProtocolTCP, Port: 3306}},
+		{target: "tcp/3306", want: Target{Protocol: ProtocolTCP, Port: 3306}},
+		{target: "UDP/53", want: Target{Protocol: ProtocolUDP, Port: 53}},
+		{target: "127.0.0.1:8080", want: Target{Protocol: ProtocolTCP, IP: "127.0.0.1", Port: 8080}},
+		{target: "udp/10.0.0.5:53", want: Target{Protocol: ProtocolUDP, IP: "10.0.0.5", Port: 53}},
+		{target: "[::1]:8080", want: Target{Protocol: ProtocolTCP, IP: "::1", Port: 8080}},
+		{target: "[2400:8500:1301:1052:a157:0007:0154:023f]:53", want: Target{Protocol: ProtocolTCP, IP: "2400:8500:1301:1052:a157:7:154:23f", Port: 53}},
+		{target: "[::ffff:127.0.0.1]:80", want: Target{Protocol: ProtocolTCP, IP: "127.0.0.1", Port: 80}},
+		{target: "unix:/var/run/mysqld/mysqld.sock", want: Target{Protocol: ProtocolUnix, Path: "/var/run/mysqld/mysqld.sock"}},
+		{target: "unix:@abstract", want: Target{Protocol: ProtocolUnix, Path: "@abstract"}},
+		{target: "65535", want: Target{Protocol: ProtocolTCP, Port: 65535}},
+		{target: "", err: true},
+		{target: "0", err: true},
+		{target: "65536", err: true},
+		{target: "-1", err: true},
+		{target: "mysql", err: true},
+		{target: "sctp/3306", err: true},
+		{target: "unix:", err: true},
+		{target: "::1:8080", err: true},
+		{target: "[::1]8080", err: true},
+		{target: "localhost:8080", err: true},
+		{target: "127.0.0.1:", err: true},
+		{target: "127.0.0.1", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseTarget(tt.target)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseTarget(%q) = %+v, want error", tt.target, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseTarget(%q) error: %v", tt.target, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseTarget(%q) = %+v, want %+v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestParseTargetRange(t *testing.T) {
+	tests := []struct {
+		target string
+		want   []string
+		err    bool
+	}{
+		{target: "3306", want: []string{"tcp/3306"}},
+		{target: "6379-6381", want: []string{"tcp/6379", "tcp/6380", "tcp/6381"}},
+		{target: "udp/53-54", want: []string{"udp/53", "udp/54"}},
+		{target: "127.0.0.1:8080-8081", want: []string{"tcp/127.0.0.1:8080", "tcp/127.0.0.1:8081"}},
+		{target: "[::1]:8080-8081", want: []string{"tcp/[::1]:8080", "tcp/[::1]:8081"}},
+		{target: "udp/[::1]:53-53", want: []string{"udp/[::1]:53"}},
+		{target: "65534-65535", want: []string{"tcp/65534", "tcp/65535"}},
+		{target: "1-1024", want: nil},
+		// reversed
+		{target: "6381-6379", err: true},
+		// zero
+		{target: "0-2", err: true},
+		{target: "0", err: true},
+		// beyond 65535
+		{target: "65535-65536", err: true},
+		{target: "70000-70001", err: true},
+		// more than MaxTargetPorts
+		{target: "1-1025", err: true},
+		{target: "6379-", err: true},
+		{target: "-6379", err: true},
+		{target: "6379-6380-6381", err: true},
+		{target: "a-b", err: true},
+		{target: "sctp/53-54", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseTargetRange(tt.target)
+		if tt.err {
+			if err == nil {
+				t.Errorf("parseTargetRange(%q) = %v, want error", tt.target, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseTargetRange(%q) error: %v", tt.target, err)
+			continue
+		}
+		if tt.want == nil {
+			// only count the ports of a large range
+			if len(got) != MaxTargetPorts || got[0].Port != 1 || got[len(got)-1].Port != MaxTargetPorts {
+				t.Errorf("parseTargetRange(%q) = %d targets, want 1 to %d", tt.target, len(got), MaxTargetPorts)
+			}
+			continue
+		}
+		var s []string
+		for _, target := range got {
+			s = append(s, target.String())
+		}
+		if !reflect.DeepEqual(s, tt.want) {
+			t.Errorf("parseTargetRange(%q) = %v, want %v", tt.target, s, tt.want)
+		}
+	}
+}
+
+func TestParseDiscoverers(t *testing.T) {
+	tests := []struct {
+		target string
+		netns  uint64
+		want   []string
+		err    bool
+	}{
+		{target: "3306,3307, 33060", want: []string{"tcp/3306", "tcp/3307", "tcp/33060"}},
+		// overlapping ranges and duplicated ports are probed once
+		{target: "6379-6381,6380-6382,6379", want: []string{"tcp/6379", "tcp/6380", "tcp/6381", "tcp/6382"}},
+		{target: "53,udp/53,tcp/53", want: []string{"tcp/53", "udp/53"}},
+		{target: "8080,127.0.0.1:8080", want: []string{"tcp/8080", "tcp/127.0.0.1:8080"}},
+		{target: "3306-3307", netns: 4026531992, want: []string{"tcp/3306 netns 4026531992", "tcp/3307 netns 4026531992"}},
+		// path and regex may contain comma and dash
+		{target: "unix:/run/a-b,c.sock", want: []string{"unix:/run/a-b,c.sock"}},
+		{target: "cmdline~mysqld.*--port=33[0-9]{2},", want: []string{"cmdline~mysqld.*--port=33[0-9]{2},"}},
+		{target: "3306,unix:/run/mysqld.sock", want: []string{"tcp/3306", "unix:/run/mysqld.sock"}},
+		{target: "1-1024,2048", err: true},
+		{target: "1-600,601-1200", err: true},
+		{target: "3306,", err: true},
+		{target: "3306,0", err: true},
+		{target: "comm:", err: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDiscoverers(tt.target, tt.netns)
+		if tt.err {
+			if err == nil {
+				t.Errorf("ParseDiscoverers(%q) = %v, want error", tt.target, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDiscoverers(%q) error: %v", tt.target, err)
+			continue
+		}
+		var s []string
+		for _, d := range got {
+			s = append(s, d.String())
+		}
+		if !reflect.DeepEqual(s, tt.want) {
+			t.Errorf("ParseDiscoverers(%q) = %v, want %v", tt.target, s, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Package listen_process
+// @Description: tcp_info of connections through NETLINK_SOCK_DIAG
+package listen_process
+
+import (
+	"encoding/binary"
+	"syscall"
+)
+
+const (
+	// offset of field in struct tcp_info, see include/uapi/linux/tcp.h
+	tcpInfoRttOffset           = 68
+	tcpInfoTotalRetransOffset  = 100
+	tcpInfoBytesAckedOffset    = 120
+	tcpInfoBytesReceivedOffset = 128
+	// tcp_info before linux 4.1 has no bytes_acked and bytes_received
+	tcpInfoMinLen = tcpInfoBytesReceivedOffset + 8
+)
+
+/*
+ *  @Description: tcp_info summary of connections accepted by listen socket
+ */
+type TcpInfoStats struct {
+	RTTs          []float64 // smoothed rtt of every connection in seconds
+	Retransmits   uint64    // sum of total_retrans
+	BytesAcked    uint64
+	BytesReceived uint64
+}
+
+/*
+ * @Description: query tcp_info of connections accepted by tcp listen sockets, the kernel is asked for
+ *               connections of one port at a time. sock_diag only sees the network namespace of this exporter,
+ *               listen sockets of other namespaces are skipped
+ * @Param lps: listen process
+ * @Return map[string]*TcpInfoStats: tcp_info by key of listen process
+ * @Return error: sock_diag is unavailable
+ */
+func CollectTcpInfo(lps []ListenProcess) (map[string]*TcpInfoStats, error) {
+	wanted := make(map[string]int, len(lps))
+	ports := make(map[uint32]struct{})
+	ret := make(map[string]*TcpInfoStats, len(lps))
+	for i, lp := range lps {
+		if lp.Protocol != ProtocolTCP || lp.Netns != selfNetns() {
+			continue
+		}
+		wanted[lp.Key()] = i
+		ports[lp.Port] = struct{}{}
+		ret[lp.Key()] = &TcpInfoStats{}
+	}
+	// time wait and new syn recv socket has no tcp_info
+	states := tcpStateMask(TcpEstablished, TcpSynRecv, TcpFinWait1, TcpFinWait2,
+		TcpClose, TcpCloseWait, TcpLastAck, TcpClosing)
+	for port := range ports {
+		for _, family := range []uint8{syscall.AF_INET, syscall.AF_INET6} {
+			entries, err := sockDiagDump(family, syscall.IPPROTO_TCP, states, 1<<(inetDiagInfo-1), port)
+			if err != nil {
+				return ret, err
+			}
+			nf := netFile{protocol: ProtocolTCP, family: uint32(family)}
+			for _, entry := range entries {
+				i, ok := connectionListener(wanted, selfNetns(), nf, entry.local())
+				if !ok {
+					continue
+				}
+				ret[lps[i].Key()].add(entry.attrs[inetDiagInfo])
+			}
+		}
+	}
+	return ret, nil
+}
+
+/*
+ * @Description: add tcp_info of one connection
+ * @Param info: struct tcp_info of INET_DIAG_INFO
+ * @Return bool: tcp_info is long enough
+ */
+func (s *TcpInfoStats) add(info []byte) bool {
+	if len(info) < tcpInfoMinLen {
+		return false
+	}
+	s.RTTs = append(s.RTTs, float64(binary.NativeEndian.Uint32(info[tcpInfoRttOffset:]))/1e6)
+	s.Retransmits += uint64(binary.NativeEndian.Uint32(info[tcpInfoTotalRetransOffset:]))
+	s.BytesAcked += binary.NativeEndian.Uint64(info[tcpInfoBytesAckedOffset:])
+	s.BytesReceived += binary.NativeEndian.Uint64(info[tcpInfoBytesReceivedOffset:])
+	return true
+}
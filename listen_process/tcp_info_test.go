@@ -0,0 +1,70 @@
+package listen_process
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestTcpInfoStatsAdd(t *testing.T) {
+	// every other field is garbage
+	info := make([]byte, 232)
+	for i := range info {
+		info[i] = 0xff
+	}
+	binary.NativeEndian.PutUint32(info[tcpInfoRttOffset:], 1500)
+	binary.NativeEndian.PutUint32(info[tcpInfoTotalRetransOffset:], 3)
+	binary.NativeEndian.PutUint64(info[tcpInfoBytesAckedOffset:], 123456789)
+	binary.NativeEndian.PutUint64(info[tcpInfoBytesReceivedOffset:], 987654)
+
+	var s TcpInfoStats
+	if !s.add(info) || !s.add(info) {
+		t.Fatal("tcp_info is not added")
+	}
+	want := TcpInfoStats{RTTs: []float64{0.0015, 0.0015}, Retransmits: 6, BytesAcked: 246913578, BytesReceived: 1975308}
+	if len(s.RTTs) != 2 || s.RTTs[0] != want.RTTs[0] || s.RTTs[1] != want.RTTs[1] || s.Retransmits != want.Retransmits ||
+		s.BytesAcked != want.BytesAcked || s.BytesReceived != want.BytesReceived {
+		t.Errorf("tcp_info stats = %+v, want %+v", s, want)
+	}
+
+	// tcp_info before linux 4.1 has no bytes_acked and bytes_received
+	if s.add(info[:104]) || len(s.RTTs) != 2 {
+		t.Errorf("short tcp_info is added, stats = %+v", s)
+	}
+}
+
+func TestCollectTcpInfo(t *testing.T) {
+	if err := netlinkAvailable(); err != nil {
+		t.Skipf("netlink sock_diag unavailable: %v", err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("listen: %v", err)
+	}
+	defer ln.Close()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	s, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	port := uint32(ln.Addr().(*net.TCPAddr).Port)
+	self := ListenProcess{IP: "127.0.0.1", Port: port, Protocol: ProtocolTCP, Netns: selfNetns()}
+	other := ListenProcess{IP: "127.0.0.1", Port: port, Protocol: ProtocolTCP, Netns: selfNetns() + 1}
+	stats, err := CollectTcpInfo([]ListenProcess{self, other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the client end is bound to another port
+	if st, ok := stats[self.Key()]; !ok || len(st.RTTs) != 1 {
+		t.Errorf("tcp_info of %s = %+v, want one accepted connection", self.Key(), st)
+	}
+	if st, ok := stats[other.Key()]; ok {
+		t.Errorf("tcp_info of other network namespace = %+v, want skipped", st)
+	}
+}
@@ -0,0 +1,78 @@
+// Package listen_process
+// @Description: tcp state of socket in /proc/net/tcp
+package listen_process
+
+import (
+	"fmt"
+	"strconv"
+)
+
+/*
+ *  @Description: field st of /proc/net/tcp, see include/net/tcp_states.h
+ */
+type TcpState uint8
+
+const (
+	TcpEstablished TcpState = 0x01
+	TcpSynSent     TcpState = 0x02
+	TcpSynRecv     TcpState = 0x03
+	TcpFinWait1    TcpState = 0x04
+	TcpFinWait2    TcpState = 0x05
+	TcpTimeWait    TcpState = 0x06
+	TcpClose       TcpState = 0x07
+	TcpCloseWait   TcpState = 0x08
+	TcpLastAck     TcpState = 0x09
+	TcpListen      TcpState = 0x0A
+	TcpClosing     TcpState = 0x0B
+	TcpNewSynRecv  TcpState = 0x0C
+)
+
+var tcpStateNames = map[TcpState]string{
+	TcpEstablished: "ESTABLISHED",
+	TcpSynSent:     "SYN_SENT",
+	TcpSynRecv:     "SYN_RECV",
+	TcpFinWait1:    "FIN_WAIT1",
+	TcpFinWait2:    "FIN_WAIT2",
+	TcpTimeWait:    "TIME_WAIT",
+	TcpClose:       "CLOSE",
+	TcpCloseWait:   "CLOSE_WAIT",
+	TcpLastAck:     "LAST_ACK",
+	TcpListen:      "LISTEN",
+	TcpClosing:     "CLOSING",
+	TcpNewSynRecv:  "NEW_SYN_RECV",
+}
+
+// ConnectionStates are states of non listening tcp socket
+var ConnectionStates = []TcpState{
+	TcpEstablished, TcpSynSent, TcpSynRecv, TcpFinWait1, TcpFinWait2, TcpTimeWait,
+	TcpClose, TcpCloseWait, TcpLastAck, TcpClosing, TcpNewSynRecv,
+}
+
+/*
+ * @Description: parse field st of /proc/net/tcp, such as 0A
+ * @Param st: hex state
+ * @Return TcpState:
+ * @Return error:
+ */
+func parseTcpState(st string) (TcpState, error) {
+	v, err := strconv.ParseUint(st, 16, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid tcp state, %s", st)
+	}
+	s := TcpState(v)
+	if _, ok := tcpStateNames[s]; !ok {
+		return 0, fmt.Errorf("unknown tcp state, %s", st)
+	}
+	return s, nil
+}
+
+func (s TcpState) String() string {
+	if name, ok := tcpStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("UNKNOWN(%d)", uint8(s))
+}
+
+func (s TcpState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
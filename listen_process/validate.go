@@ -0,0 +1,83 @@
+// Package listen_process
+// @Description: detect stale listen process in the cache
+package listen_process
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+
+	"listen_process_exporter/comm"
+)
+
+/*
+ * @Description: find listen process out of date since the cache was refreshed: the process exited and its pid
+ *               may be reused by another process, or the listen socket was closed and opened again
+ * @Param ctx: socket tables shared by WithSocketTables are not read again
+ * @Param lps: listen process from the cache
+ * @Return map[string]bool: key of stale listen process
+ */
+func staleListenProcess(ctx context.Context, lps []ListenProcess) map[string]bool {
+	stale := make(map[string]bool)
+	for _, lp := range lps {
+		if !processAlive(lp) {
+			stale[lp.Key()] = true
+		}
+	}
+	inodes := listenInodes(ctx, lps)
+	for _, lp := range lps {
+		for _, inode := range lp.Inodes {
+			if _, ok := inodes[lp.Netns][inode]; !ok {
+				stale[lp.Key()] = true
+				break
+			}
+		}
+	}
+	if comm.Debug() {
+		for k := range stale {
+			log.Printf("listen %s is stale", k)
+		}
+	}
+	return stale
+}
+
+/*
+ *  @Description: every process holding the socket is still the one found, compared by start time
+ */
+func processAlive(lp ListenProcess) bool {
+	for _, p := range lp.Processes {
+		stat, err := readProcessStat(p.Pid)
+		if err != nil || stat.startTime != p.StartTime {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ * @Description: inode of listen sockets in the network namespaces of listen processes
+ * @Param ctx:
+ * @Param lps:
+ * @Return map[uint64]map[string]struct{}: inodes by network namespace
+ */
+func listenInodes(ctx context.Context, lps []ListenProcess) map[uint64]map[string]struct{} {
+	protocols := make(map[string]bool)
+	for _, lp := range lps {
+		protocols[lp.Protocol] = true
+	}
+	ret := make(map[uint64]map[string]struct{})
+	for netns, dir := range netDirs(lps) {
+		inodes := make(map[string]struct{})
+		scanSocketTable(ctx, dir, protocols, true, func(nf netFile, sl socketLine) {
+			inodes[sl.inode] = struct{}{}
+		})
+		if protocols[ProtocolUnix] {
+			sockets, _ := socketTablesFrom(ctx).unixListenSockets(ctx, filepath.Join(dir, "unix"))
+			for _, s := range sockets {
+				inodes[s.Inode] = struct{}{}
+			}
+		}
+		ret[netns] = inodes
+	}
+	return ret
+}
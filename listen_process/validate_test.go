@@ -0,0 +1,102 @@
+package listen_process
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+/*
+ * @Description: copy stat of processes and socket tables of the fixture to a temp proc dir which the test can modify
+ * @Param t:
+ * @Return string: proc dir
+ */
+func copyFixtureProc(t *testing.T) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, name := range []string{"1234/stat", "1240/stat", "net/tcp", "net/tcp6", "net/udp", "net/udp6", "net/unix"} {
+		b, err := os.ReadFile(filepath.Join(fixtureProcDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.WriteFile(filepath.Join(root, name), b, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	useSelfNetns(t)
+	linkFakeNetns(t, filepath.Join(root, "self"), 4026531992)
+	useProcDir(t, root)
+	return root
+}
+
+/*
+ *  @Description: replace text of a file in the fake proc dir
+ */
+func replaceFile(t *testing.T, path string, old string, new string) {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), old) {
+		t.Fatalf("%s has no %q", path, old)
+	}
+	if err = os.WriteFile(path, []byte(strings.Replace(string(b), old, new, 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStaleListenProcess(t *testing.T) {
+	mysqld := ListenProcess{Pid: 1234, IP: "127.0.0.1", Port: 3306, Protocol: ProtocolTCP, Netns: 4026531992,
+		Inodes: []string{"20001"}, Processes: []SocketProcess{
+			{Pid: 1234, Ppid: 1, Role: RoleMaster, StartTime: 321104591},
+			{Pid: 1240, Ppid: 1234, Role: RoleWorker, StartTime: 321104700},
+		}}
+	sshd := ListenProcess{Pid: 1234, IP: "0.0.0.0", Port: 22, Protocol: ProtocolTCP, Netns: 4026531992,
+		Inodes: []string{"20002"}, Processes: []SocketProcess{{Pid: 1234, Ppid: 1, Role: RoleMaster, StartTime: 321104591}}}
+	tests := []struct {
+		name   string
+		change func(t *testing.T, root string)
+		stale  map[string]bool
+	}{
+		{name: "unchanged", change: func(t *testing.T, root string) {}, stale: map[string]bool{}},
+		{name: "pid reused", change: func(t *testing.T, root string) {
+			// worker exited and its pid was taken by a process started later
+			replaceFile(t, filepath.Join(root, "1240", "stat"), " 321104700 ", " 321190000 ")
+		}, stale: map[string]bool{mysqld.Key(): true}},
+		{name: "holder exited", change: func(t *testing.T, root string) {
+			if err := os.RemoveAll(filepath.Join(root, "1240")); err != nil {
+				t.Fatal(err)
+			}
+		}, stale: map[string]bool{mysqld.Key(): true}},
+		{name: "inode replaced", change: func(t *testing.T, root string) {
+			// restarted listener binds the same address with a new socket
+			replaceFile(t, filepath.Join(root, "net", "tcp"), " 20001 ", " 20101 ")
+		}, stale: map[string]bool{mysqld.Key(): true}},
+		{name: "not listening", change: func(t *testing.T, root string) {
+			// holders are alive but the socket was shut down, it is still bound
+			replaceFile(t, filepath.Join(root, "net", "tcp"), "0100007F:0CEA 00000000:0000 0A", "0100007F:0CEA 00000000:0000 07")
+		}, stale: map[string]bool{mysqld.Key(): true}},
+		{name: "master exited", change: func(t *testing.T, root string) {
+			if err := os.RemoveAll(filepath.Join(root, "1234")); err != nil {
+				t.Fatal(err)
+			}
+		}, stale: map[string]bool{mysqld.Key(): true, sshd.Key(): true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := copyFixtureProc(t)
+			tt.change(t, root)
+			lps := []ListenProcess{mysqld, sshd}
+			if stale := staleListenProcess(WithSocketTables(context.Background()), lps); !reflect.DeepEqual(stale, tt.stale) {
+				t.Errorf("stale = %v, want %v", stale, tt.stale)
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -24,13 +25,41 @@ var (
 		"Addresses on which to expose metrics and web")
 	metricsPath = flag.String("web.telemetry-path", "/metrics",
 		"Path under which to expose metrics")
-	//collectChildProcess          = flag.Bool("collector.child", false, "Enable the collect child process (default: disable).")
+	collectChildProcess          = flag.Bool("collector.child", false, "Enable the collect child process (default: disable).")
+	childRoleFile                = flag.String("collector.child.role-file", "", "Json file of rules classifying child processes of listen port into roles by cmdline or comm regex.")
 	refreshListenProcessInterval = flag.Int("collector.refresh", 60, "Refresh listen process interval second (default: 60s).")
 	collectListenPort            = flag.Int("collector.port", 3306, "Collect listen port (default: 3306).")
 	debug                        = flag.Bool("collector.debug", false, "Enable debug mode.")
+	clientTopN                   = flag.Int("collector.client.top", 0, "Report top N clients connected to listen port (default: 0, disable).")
+	clientIPv4Prefix             = flag.Int("collector.client.ipv4-prefix", 32, "Aggregate ipv4 clients to network of prefix length (default: 32).")
+	clientIPv6Prefix             = flag.Int("collector.client.ipv6-prefix", 128, "Aggregate ipv6 clients to network of prefix length (default: 128).")
+	listenDiscoverer             = flag.String("collector.discoverer", listen_process.DiscovererProcfs, "Backend to discover listen socket, procfs or netlink (default: procfs).")
+	scanWorkers                  = flag.Int("collector.scan.workers", listen_process.DefaultScanWorkers, "Number of pids whose fd are scanned in parallel (default: 4).")
+	scanPidTimeout               = flag.Duration("collector.scan.pid-timeout", listen_process.DefaultScanPidTimeout, "Skip pid whose fd scan is slower than this (default: 1s).")
+	scanAllHolders               = flag.Bool("collector.scan.all-holders", false, "Scan every pid to find all processes holding listen socket, such as pre-fork workers and SO_REUSEPORT (default: disable, stop once every socket has a holder).")
+	procfsPath                   = flag.String("path.procfs", comm.DefaultProcDir, "procfs mountpoint, such as /host/proc in container (default: /proc).")
+	collectThreads               = flag.Bool("collector.threads", false, "Enable per thread cpu, context switches, io and state of listen process (default: disable).")
+	threadNameRules              stringsFlag
+	collectTcpInfo               = flag.Bool("collector.tcp-info", false, "Enable tcp_info of connections through netlink sock_diag (default: disable).")
+	collectSmaps                 = flag.Bool("collector.smaps", false, "Enable pss, uss, swap pss and anon huge pages from /proc/[pid]/smaps_rollup (default: disable).")
 )
 
+/*
+ *  @Description: flag which may be set several times
+ */
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
 func main() {
+	flag.Var(&threadNameRules, "collector.threads.name-rule", "Normalize thread name by regex=name, such as connection.*=connection, may be repeated.")
 	flag.Parse()
 	if *version {
 		fmt.Println(comm.Version)
@@ -45,12 +74,46 @@ func main() {
 	if *debug {
 		comm.SetDebug(*debug)
 	}
+	if err := comm.SetProcDir(*procfsPath); err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	if err := exporter.SetClientTopN(*clientTopN, *clientIPv4Prefix, *clientIPv6Prefix); err != nil {
+		log.Fatal(err)
+		return
+	}
+	if err := exporter.SetChildRoleFile(*childRoleFile); err != nil {
+		log.Fatal(err)
+		return
+	}
+	if err := exporter.SetThreadCollector(*collectThreads, threadNameRules); err != nil {
+		log.Fatal(err)
+		return
+	}
+	if *collectTcpInfo {
+		exporter.SetTcpInfo(*collectTcpInfo)
+	}
+	if *collectSmaps {
+		exporter.SetSmaps(*collectSmaps)
+	}
 
 	handlerFunc := newHandler()
 	http.Handle(*metricsPath, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer, handlerFunc))
 
 	http.Handle("/health", handler.Health())
 
+	if err := listen_process.SetListenDiscoverer(*listenDiscoverer); err != nil {
+		log.Fatal(err)
+		return
+	}
+	if err := listen_process.SetScanOption(*scanWorkers, *scanPidTimeout); err != nil {
+		log.Fatal(err)
+		return
+	}
+	if *scanAllHolders {
+		listen_process.SetScanAllHolders(*scanAllHolders)
+	}
 	if err := listen_process.SetTickerInterval(*refreshListenProcessInterval); err != nil {
 		log.Fatal(err)
 		return
@@ -58,7 +121,7 @@ func main() {
 	go listen_process.RefreshListenProcessGoroutine()
 
 	//http.Handle(*metricsPath, promhttp.Handler())
-	http.HandleFunc("/probe", handler.HandleProbe(false))
+	http.HandleFunc("/probe", handler.HandleProbe(*collectChildProcess))
 	http.HandleFunc("/refresh_listen_process", handler.HandleRefreshListenProcess())
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -82,13 +145,18 @@ func newHandler() http.HandlerFunc {
 		if q.Has("target") {
 			target = q.Get("target")
 		}
-		listenPort, err := strconv.Atoi(target)
+		netns, err := listen_process.ParseNetns(q.Get("netns"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		discoverers, err := listen_process.ParseDiscoverers(target, netns)
 		if err != nil {
-			http.Error(w, fmt.Sprintf("target[%s] must be number", target), http.StatusBadRequest)
+			http.Error(w, fmt.Sprintf("target[%s] invalid: %v", target, err), http.StatusBadRequest)
 			return
 		}
 		registry := prometheus.NewRegistry()
-		registry.MustRegister(exporter.NewExporter(false, listenPort))
+		registry.MustRegister(exporter.NewExporter(r.Context(), *collectChildProcess, discoverers))
 
 		gatherers := prometheus.Gatherers{
 			prometheus.DefaultGatherer,